@@ -15,6 +15,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -31,11 +32,35 @@ type benchCtx struct {
 	tableCount    string
 	tableCountInt int
 	rowCount      string
+	rowCountInt   int
+	resultDir     string
+	threshold     float64
+	matrixPath    string
+	promAddr      string
+	promStep      time.Duration
+	promListen    string
+
+	podImage        string
+	podPullPolicy   string
+	podCPU          string
+	podMemory       string
+	podNodeSelector map[string]string
+	podPullSecrets  []string
+	podAvoidTiKV    bool
+	podTimeout      time.Duration
+
+	detach   bool
+	local    bool
+	jobImage string
+	jobHold  time.Duration
+	tidbHost string
 
 	clientset *kubernetes.Clientset
 	config    *rest.Config
 	benchName string
 	tidbSVC   string
+	promSVC   string
+	result    *benchResult
 }
 
 func init() {
@@ -49,40 +74,132 @@ func init() {
 	benchCmd.Flags().StringVar(&bCtx.dataset, "dataset", "sysbench", "Set the dataset to prepare.")
 	benchCmd.Flags().StringVar(&bCtx.tableCount, "tables", "1", "Set the table count of dataset.")
 	benchCmd.Flags().StringVar(&bCtx.rowCount, "rows", "100", "Set the row count of dataset.")
+	benchCmd.Flags().StringVar(&bCtx.resultDir, "result-dir", "./bench-results", "Set the directory to store bench results.")
+	benchCmd.Flags().StringVar(&bCtx.matrixPath, "matrix", "", "Set the path of a sweep file to bench every combination of settings.")
+	benchCmd.Flags().StringVar(&bCtx.promAddr, "prometheus", "", "Set the Prometheus address, defaults to the monitor service in the namespace.")
+	benchCmd.Flags().DurationVar(&bCtx.promStep, "prom-step", 15*time.Second, "Set the step of Prometheus range queries.")
+	benchCmd.Flags().StringVar(&bCtx.promListen, "prom-listen", "127.0.0.1:9090", "Set the listen address of prom-stub.")
+	benchCmd.Flags().StringVar(&bCtx.podImage, "image", "hub.pingcap.net/perf_testing/bench-toolset:latest", "Set the image of bench pod.")
+	benchCmd.Flags().StringVar(&bCtx.podPullPolicy, "pull-policy", string(apiv1.PullAlways), "Set the image pull policy of bench pod, supports Always, IfNotPresent, Never.")
+	benchCmd.Flags().StringVar(&bCtx.podCPU, "cpu", "", "Set the CPU request of bench pod, e.g. 4.")
+	benchCmd.Flags().StringVar(&bCtx.podMemory, "memory", "", "Set the memory request of bench pod, e.g. 8Gi.")
+	benchCmd.Flags().StringToStringVar(&bCtx.podNodeSelector, "node-selector", nil, "Set the node selector of bench pod, e.g. role=bench.")
+	benchCmd.Flags().StringSliceVar(&bCtx.podPullSecrets, "image-pull-secret", nil, "Set the image pull secrets of bench pod.")
+	benchCmd.Flags().BoolVar(&bCtx.podAvoidTiKV, "avoid-tikv", true, "Prefer scheduling bench pod on nodes without TiKV.")
+	benchCmd.Flags().DurationVar(&bCtx.podTimeout, "pod-timeout", 5*time.Minute, "Set the timeout of waiting for bench pod.")
+	benchCmd.Flags().BoolVar(&bCtx.detach, "detach", false, "Submit the benchmark as a Kubernetes Job instead of running it from here.")
+	benchCmd.Flags().StringVar(&bCtx.jobImage, "job-image", "", "Set the dbtool image used by --detach, see resource/dbtool.Dockerfile.")
+	benchCmd.Flags().DurationVar(&bCtx.jobHold, "job-hold", time.Hour, "Keep the pod of a --detach job for this long after the run, so that fetch can copy the results from it.")
+	benchCmd.Flags().BoolVar(&bCtx.local, "local", false, "Run commands locally instead of on the bench pod, used inside the bench job.")
+	benchCmd.Flags().StringVar(&bCtx.tidbHost, "tidb-host", "", "Set the TiDB host for --local mode.")
+	benchCmd.Flags().Float64Var(&bCtx.threshold, "threshold", 0.1, "Set the relative slowdown reported as a regression by compare.")
 	rootCmd.AddCommand(benchCmd)
 }
 
 func runBenchCmd(d *benchCtx) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
-		if len(args) > 1 {
-			cmd.Usage()
-			return
+		if d.promStep <= 0 {
+			panic(fmt.Sprintf("invalid --prom-step %s, it must be positive", d.promStep))
 		}
-
-		if len(args) == 1 {
+		if len(args) > 0 {
 			switch args[0] {
 			case "clean":
 				d.init()
 				d.deleteBenchToolDeployment()
 				return
+			case "compare":
+				if len(args) != 3 {
+					fmt.Println("Usage: \n  bench compare <run-a> <run-b>")
+					return
+				}
+				d.compareResults(args[1], args[2])
+				return
+			case "metrics":
+				if len(args) != 2 {
+					fmt.Println("Usage: \n  bench metrics <run>")
+					return
+				}
+				r := loadBenchResult(d.resultDir, args[1])
+				if d.promAddr == "" {
+					d.init()
+					d.deployBenchTool()
+				}
+				d.collectMetrics(r)
+				return
+			case "logs", "fetch":
+				if len(args) != 2 {
+					fmt.Printf("Usage: \n  bench %s <run>\n", args[0])
+					return
+				}
+				d.init()
+				if args[0] == "logs" {
+					d.streamBenchJobLogs(args[1])
+				} else {
+					d.fetchBenchJobResults(args[1])
+				}
+				return
+			case "prom-stub":
+				var path string
+				if len(args) > 1 {
+					path = args[1]
+				}
+				runPromStub(path, d.promListen)
+				return
 			default:
 			}
 		}
+		if len(args) > 1 {
+			cmd.Usage()
+			return
+		}
+
+		if d.local {
+			if d.tidbHost == "" {
+				panic("--tidb-host is required in --local mode")
+			}
+			d.validateAndFillArgs()
+			d.tidbSVC = d.tidbHost
+			d.runLocalBench()
+			return
+		}
 
 		d.init()
+		if d.detach {
+			d.submitBenchJob()
+			return
+		}
 		d.deployBenchTool()
 
-		switch d.dataset {
-		case "sysbench":
-			d.sysbenchPrepare()
-			d.benchCreateMultiIndexes()
-			// d.benchCreateIndex()
-		default:
-			panic(fmt.Sprintf("unsupported dataset: %s", d.dataset))
+		if d.matrixPath != "" {
+			d.runMatrix()
+			return
 		}
+		d.runBench(nil)
 	}
 }
 
+// runBench runs one benchmark on the deployed bench tool and saves its result.
+func (b *benchCtx) runBench(labels map[string]string) *benchResult {
+	b.result = newBenchResult(b)
+	b.result.Labels = labels
+	b.collectClusterInfo()
+	switch b.dataset {
+	case "sysbench":
+		start := time.Now()
+		b.sysbenchPrepare()
+		b.result.addPhase("prepare", start)
+		start = time.Now()
+		b.benchCreateMultiIndexes()
+		b.result.addPhase("create-indexes", start)
+		// b.benchCreateIndex()
+	default:
+		panic(fmt.Sprintf("unsupported dataset: %s", b.dataset))
+	}
+	b.saveResult()
+	b.collectMetrics(b.result)
+	return b.result
+}
+
 func (b *benchCtx) init() {
 	b.validateAndFillArgs()
 	b.buildClientSetFromCfg()
@@ -105,27 +222,34 @@ func (b *benchCtx) buildClientSetFromCfg() {
 }
 
 func (b *benchCtx) detectNamespace() {
-	if b.tidbNamespace != "" {
-		log.Printf("Use specified namespace: %s\n", b.tidbNamespace)
-		return
+	b.tidbNamespace = detectNamespace(b.clientset, b.kubeCfgPath, b.tidbNamespace)
+}
+
+// detectNamespace returns the specified namespace, or the namespace of the
+// current context, or the only namespace in the cluster.
+func detectNamespace(clientset *kubernetes.Clientset, kubeCfgPath, namespace string) string {
+	if namespace != "" {
+		log.Printf("Use specified namespace: %s\n", namespace)
+		return namespace
 	}
 	log.Println("Namespace is not specified, use current context namespace...")
-	apiCfg := clientcmd.GetConfigFromFileOrDie(b.kubeCfgPath)
-	b.tidbNamespace = apiCfg.Contexts[apiCfg.CurrentContext].Namespace
-	if b.tidbNamespace != "" {
-		log.Printf("Use current context namespace: %s\n", b.tidbNamespace)
-		return
+	apiCfg := clientcmd.GetConfigFromFileOrDie(kubeCfgPath)
+	namespace = apiCfg.Contexts[apiCfg.CurrentContext].Namespace
+	if namespace != "" {
+		log.Printf("Use current context namespace: %s\n", namespace)
+		return namespace
 	}
 	log.Println("Namespace is not specified, detecting namespace...")
-	ns, err := b.clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	ns, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		panic(err)
 	}
 	if len(ns.Items) != 1 {
 		panic("namespace is not specified and there are multiple namespaces.")
 	}
-	b.tidbNamespace = ns.Items[0].Name
-	log.Printf("Detected namespace: %s\n", b.tidbNamespace)
+	namespace = ns.Items[0].Name
+	log.Printf("Detected namespace: %s\n", namespace)
+	return namespace
 }
 
 func (b *benchCtx) detectClusterInfo() {
@@ -154,6 +278,11 @@ func (b *benchCtx) validateAndFillArgs() {
 		panic(err)
 	}
 	b.tableCountInt = tableCountInt
+	rowCountInt, err := strconv.Atoi(b.rowCount)
+	if err != nil {
+		panic(err)
+	}
+	b.rowCountInt = rowCountInt
 }
 
 func (b *benchCtx) deployBenchTool() {
@@ -172,22 +301,25 @@ func (b *benchCtx) deployBenchTool() {
 	}
 
 	log.Println("Creating deployment...")
-	_, err = cli.Create(context.TODO(), benchToolDeployment, metav1.CreateOptions{})
+	deployment, err := b.benchToolDeployment()
+	mustNil(err)
+	_, err = cli.Create(context.TODO(), deployment, metav1.CreateOptions{})
 	if err != nil {
 		panic(err)
 	}
 
-	b.waitPodStatus(podStatusRunning)
+	err = b.waitPodStatus(podStatusRunning)
+	mustNil(err)
 	log.Printf("Created deployment %q.\n", b.benchName)
 }
 
 func (b *benchCtx) executeSQLInTestDB(sql string) string {
-	cmd := fmt.Sprintf("mysql -h %s -P 4000 -u root -D test -e '%s'", b.tidbSVC, sql)
+	cmd := fmt.Sprintf("mysql -t -h %s -P 4000 -u root -D test -e '%s'", b.tidbSVC, sql)
 	return b.execCmdOnPod(cmd)
 }
 
 func (b *benchCtx) executeSQL(sql string) string {
-	cmd := fmt.Sprintf("mysql -h %s -P 4000 -u root -e '%s'", b.tidbSVC, sql)
+	cmd := fmt.Sprintf("mysql -t -h %s -P 4000 -u root -e '%s'", b.tidbSVC, sql)
 	return b.execCmdOnPod(cmd)
 }
 
@@ -238,14 +370,26 @@ func (b *benchCtx) benchCreateIndex() {
 
 func (b *benchCtx) benchCreateMultiIndexes() {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	wg.Add(b.tableCountInt)
 	startTime := time.Now()
 	log.Printf("Create multiple indexes begin time: %s\n", startTime.String())
 	for i := 0; i < b.tableCountInt; i++ {
 		go func(i int) {
 			defer wg.Done()
-			ret := b.executeSQLInTestDB(fmt.Sprintf("create index idx on sbtest%d(c);", i+1))
+			table := fmt.Sprintf("sbtest%d", i+1)
+			sql := fmt.Sprintf("create index idx on %s(c);", table)
+			start := time.Now()
+			ret := b.executeSQLInTestDB(sql)
+			elapsed := time.Since(start)
 			fmt.Print(ret)
+			if b.result != nil {
+				t := ddlTiming{Table: table, SQL: sql, Seconds: elapsed.Seconds()}
+				b.collectJobStat(&t)
+				mu.Lock()
+				b.result.DDL = append(b.result.DDL, t)
+				mu.Unlock()
+			}
 		}(i)
 	}
 	wg.Wait()
@@ -276,6 +420,25 @@ func extractSQLResult(result string, rowIdx int, colIdxes ...int) []string {
 	return ret
 }
 
+// extractSQLRows returns the given columns of every data row in a mysql client table output.
+func extractSQLRows(result string, colIdxes ...int) [][]string {
+	rows := strings.Split(result, "\n")
+	var ret [][]string
+	for i := 3; i < len(rows); i++ { // skip header.
+		str := strings.TrimRight(rows[i], "\r")
+		if !strings.HasPrefix(str, "|") {
+			continue
+		}
+		cols := strings.Split(str, "|")
+		row := make([]string, 0, len(colIdxes))
+		for _, idx := range colIdxes {
+			row = append(row, strings.Trim(cols[idx+1], " "))
+		}
+		ret = append(ret, row)
+	}
+	return ret
+}
+
 func (b *benchCtx) getRunningBenchPodName() {
 	opts := metav1.ListOptions{
 		TypeMeta:      metav1.TypeMeta{},
@@ -303,46 +466,89 @@ const (
 	podStatusDeleted = 2
 )
 
-func (b *benchCtx) waitPodStatus(status podStatus) {
+func (b *benchCtx) waitPodStatus(status podStatus) error {
 	opts := metav1.ListOptions{
 		TypeMeta:      metav1.TypeMeta{},
 		LabelSelector: "app=bench",
 		FieldSelector: "",
 	}
 
+	pods, err := b.clientset.CoreV1().Pods(b.tidbNamespace).List(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if status == podStatusDeleted {
+		if len(pods.Items) == 0 {
+			log.Println("The pod is deleted.")
+			return nil
+		}
+		// Watch from the listed version, so a deletion in between is not missed.
+		opts.ResourceVersion = pods.ResourceVersion
+	}
 	w, err := b.clientset.CoreV1().Pods(b.tidbNamespace).Watch(context.Background(), opts)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	defer w.Stop()
 
+	timeout := time.After(b.podTimeout)
 	for {
 		select {
-		case event := <-w.ResultChan():
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return fmt.Errorf("watch bench pod closed unexpectedly")
+			}
 			switch status {
 			case podStatusDeleted:
 				if event.Type == watch.Deleted {
 					log.Println("The pod is deleted.")
-					return
+					return nil
 				}
 			case podStatusRunning:
-				pod := event.Object.(*apiv1.Pod)
+				pod, ok := event.Object.(*apiv1.Pod)
+				if !ok {
+					continue
+				}
 				if pod.Status.Phase == apiv1.PodRunning {
 					b.benchName = pod.Name
 					log.Printf("The pod %s is running.", pod.Name)
-					return
+					return nil
+				}
+				if err := checkPodFailure(pod); err != nil {
+					return err
 				}
 			default:
 				panic("unknown pod status")
 			}
-		case <-time.After(1 * time.Minute):
-			log.Println("Wait for pod running timeout.")
-			return
+		case <-timeout:
+			return fmt.Errorf("wait for bench pod timeout after %s", b.podTimeout)
 		}
 	}
 }
 
+// checkPodFailure returns an error if the pod cannot become running by itself.
+func checkPodFailure(pod *apiv1.Pod) error {
+	if pod.Status.Phase == apiv1.PodFailed {
+		return fmt.Errorf("pod %s failed: %s", pod.Name, pod.Status.Message)
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting == nil {
+			continue
+		}
+		switch cs.State.Waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CrashLoopBackOff", "CreateContainerConfigError":
+			return fmt.Errorf("pod %s is not ready: %s: %s", pod.Name, cs.State.Waiting.Reason, cs.State.Waiting.Message)
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == apiv1.PodScheduled && cond.Status == apiv1.ConditionFalse && cond.Reason == apiv1.PodReasonUnschedulable {
+			log.Printf("The pod %s is unschedulable: %s", pod.Name, cond.Message)
+		}
+	}
+	return nil
+}
+
 func (b *benchCtx) deleteBenchToolDeployment() {
 	log.Println("Deleting deployment...")
 	cli := b.clientset.AppsV1().Deployments(b.tidbNamespace)
@@ -353,11 +559,15 @@ func (b *benchCtx) deleteBenchToolDeployment() {
 	}); err != nil {
 		panic(err)
 	}
-	b.waitPodStatus(podStatusDeleted)
+	err := b.waitPodStatus(podStatusDeleted)
+	mustNil(err)
 	log.Println("Deleted deployment.")
 }
 
 func (b *benchCtx) execCmdOnPod(command string) string {
+	if b.local {
+		return execCmdLocally(command)
+	}
 	req := b.clientset.CoreV1().
 		RESTClient().
 		Post().
@@ -410,36 +620,89 @@ func (b *buffer) String() string {
 	return string(b.data)
 }
 
-var benchToolDeployment = &appsv1.Deployment{
-	ObjectMeta: metav1.ObjectMeta{
-		Name: "bench",
-	},
-	Spec: appsv1.DeploymentSpec{
-		Replicas: int32Ptr(1),
-		Selector: &metav1.LabelSelector{
-			MatchLabels: map[string]string{
-				"app": "bench",
-			},
+func (b *benchCtx) benchToolDeployment() (*appsv1.Deployment, error) {
+	podSpec, err := b.benchPodSpec(apiv1.Container{
+		Name:            "bench",
+		Image:           b.podImage,
+		Command:         []string{"tail"},
+		Args:            []string{"-f", "/dev/null"},
+		ImagePullPolicy: apiv1.PullPolicy(b.podPullPolicy),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "bench",
 		},
-		Template: apiv1.PodTemplateSpec{
-			ObjectMeta: metav1.ObjectMeta{
-				Labels: map[string]string{
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(1),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
 					"app": "bench",
 				},
 			},
-			Spec: apiv1.PodSpec{
-				Containers: []apiv1.Container{
-					{
-						Name:            "bench",
-						Image:           "hub.pingcap.net/perf_testing/bench-toolset:latest",
-						Command:         []string{"tail"},
-						Args:            []string{"-f", "/dev/null"},
-						ImagePullPolicy: apiv1.PullAlways,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": "bench",
 					},
 				},
+				Spec: podSpec,
 			},
 		},
-	},
+	}, nil
+}
+
+// benchPodSpec wraps the container with the resource and scheduling settings of bench pods.
+func (b *benchCtx) benchPodSpec(container apiv1.Container) (apiv1.PodSpec, error) {
+	switch container.ImagePullPolicy {
+	case apiv1.PullAlways, apiv1.PullIfNotPresent, apiv1.PullNever:
+	default:
+		return apiv1.PodSpec{}, fmt.Errorf("invalid image pull policy %q", b.podPullPolicy)
+	}
+	requests := apiv1.ResourceList{}
+	if b.podCPU != "" {
+		q, err := resource.ParseQuantity(b.podCPU)
+		if err != nil {
+			return apiv1.PodSpec{}, fmt.Errorf("invalid cpu request %q: %w", b.podCPU, err)
+		}
+		requests[apiv1.ResourceCPU] = q
+	}
+	if b.podMemory != "" {
+		q, err := resource.ParseQuantity(b.podMemory)
+		if err != nil {
+			return apiv1.PodSpec{}, fmt.Errorf("invalid memory request %q: %w", b.podMemory, err)
+		}
+		requests[apiv1.ResourceMemory] = q
+	}
+	container.Resources.Requests = requests
+
+	podSpec := apiv1.PodSpec{
+		Containers:   []apiv1.Container{container},
+		NodeSelector: b.podNodeSelector,
+	}
+	for _, secret := range b.podPullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, apiv1.LocalObjectReference{Name: secret})
+	}
+	if b.podAvoidTiKV {
+		// Prefer nodes without TiKV so that the load generator does not compete with storage.
+		podSpec.Affinity = &apiv1.Affinity{
+			PodAntiAffinity: &apiv1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.WeightedPodAffinityTerm{{
+					Weight: 100,
+					PodAffinityTerm: apiv1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app.kubernetes.io/component": "tikv"},
+						},
+						TopologyKey: "kubernetes.io/hostname",
+					},
+				}},
+			},
+		}
+	}
+	return podSpec, nil
 }
 
 func int32Ptr(i int32) *int32 { return &i }
@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/kubectl/pkg/scheme"
+)
+
+const (
+	artifactBegin = "=== dbtool artifact: "
+	artifactEnd   = "=== dbtool artifact end ==="
+	benchDoneMark = "=== dbtool bench done: "
+	benchJobLabel = "dbtool/bench-run"
+
+	benchJobResultDir = "/tmp/bench-results"
+	// benchJobDoneFile is written to the result directory of the bench job
+	// when the run is over, it holds "succeeded" or "failed".
+	benchJobDoneFile = ".done"
+)
+
+// submitBenchJob runs the benchmark inside the cluster as a Job, so it does not
+// depend on the connection from the local machine.
+func (b *benchCtx) submitBenchJob() {
+	if b.matrixPath != "" {
+		panic("--detach does not support --matrix")
+	}
+	if b.jobImage == "" {
+		panic("--job-image is required with --detach")
+	}
+	run := time.Now().Format("20060102-150405")
+	args := []string{
+		"bench", "--local",
+		"--namespace", b.tidbNamespace,
+		"--tidb-host", b.tidbSVC,
+		"--dataset", b.dataset,
+		"--tables", b.tableCount,
+		"--rows", b.rowCount,
+		"--result-dir", benchJobResultDir,
+		"--prom-step", b.promStep.String(),
+		"--job-hold", b.jobHold.String(),
+	}
+	promAddr := b.promAddr
+	if promAddr == "" && b.detectPrometheus() {
+		promAddr = fmt.Sprintf("http://%s:9090", b.promSVC)
+	}
+	if promAddr != "" {
+		args = append(args, "--prometheus", promAddr)
+	}
+
+	container := apiv1.Container{
+		Name:            "bench",
+		Image:           b.jobImage,
+		Command:         []string{"dbtool"},
+		Args:            args,
+		ImagePullPolicy: apiv1.PullPolicy(b.podPullPolicy),
+	}
+	podSpec, err := b.benchPodSpec(container)
+	mustNil(err)
+	podSpec.RestartPolicy = apiv1.RestartPolicyNever
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   benchJobName(run),
+			Labels: map[string]string{benchJobLabel: run},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32Ptr(0),
+			TTLSecondsAfterFinished: int32Ptr(int32((7 * 24 * time.Hour).Seconds())),
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{benchJobLabel: run},
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	_, err = b.clientset.BatchV1().Jobs(b.tidbNamespace).Create(context.Background(), job, metav1.CreateOptions{})
+	mustNil(err)
+	log.Printf("Submitted bench job %q, run ID: %s\n", job.Name, run)
+	fmt.Printf("Follow progress:  dbtool bench logs %s\n", run)
+	fmt.Printf("Fetch results:    dbtool bench fetch %s\n", run)
+}
+
+func benchJobName(run string) string {
+	return "bench-" + run
+}
+
+// getBenchJobPod returns the pod of a bench job, waiting until it has started.
+func (b *benchCtx) getBenchJobPod(run string) *apiv1.Pod {
+	var pod *apiv1.Pod
+	err := wait.PollUntilContextTimeout(context.Background(), 2*time.Second, b.podTimeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := b.clientset.CoreV1().Pods(b.tidbNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", benchJobLabel, run),
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(pods.Items) == 0 {
+			return false, nil
+		}
+		pod = &pods.Items[0]
+		if pod.Status.Phase != apiv1.PodPending {
+			return true, nil
+		}
+		return false, checkPodFailure(pod)
+	})
+	mustNil(err)
+	return pod
+}
+
+func (b *benchCtx) benchJobLogs(run string, follow bool) io.ReadCloser {
+	pod := b.getBenchJobPod(run)
+	req := b.clientset.CoreV1().Pods(b.tidbNamespace).GetLogs(pod.Name, &apiv1.PodLogOptions{Follow: follow})
+	stream, err := req.Stream(context.Background())
+	mustNil(err)
+	return stream
+}
+
+// runLocalBench runs the benchmark inside the bench job. The result files are
+// printed to the logs even if the run panics, then kept on the pod for
+// --job-hold so that fetch can copy them instead of scraping the logs.
+func (b *benchCtx) runLocalBench() {
+	defer func() {
+		r := recover()
+		status := "succeeded"
+		if r != nil {
+			status = "failed"
+			log.Printf("Bench failed: %v\n", r)
+		}
+		if b.result != nil {
+			if r != nil {
+				b.saveResult()
+			}
+			printArtifacts(b.resultDir, b.result.ID)
+		}
+		err := os.MkdirAll(b.resultDir, 0755)
+		mustNil(err)
+		err = os.WriteFile(filepath.Join(b.resultDir, benchJobDoneFile), []byte(status), 0644)
+		mustNil(err)
+		fmt.Printf("%s%s ===\n", benchDoneMark, status)
+		if b.jobHold > 0 {
+			log.Printf("Keep the results on the pod for %s.\n", b.jobHold)
+			time.Sleep(b.jobHold)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	b.runBench(nil)
+}
+
+// streamBenchJobLogs prints the progress of a bench job until it finishes.
+func (b *benchCtx) streamBenchJobLogs(run string) {
+	stream := b.benchJobLogs(run, true)
+	defer stream.Close()
+	inArtifact := false
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, artifactBegin):
+			inArtifact = true
+			fmt.Printf("[artifact %s]\n", strings.TrimSuffix(strings.TrimPrefix(line, artifactBegin), " ==="))
+		case line == artifactEnd:
+			inArtifact = false
+		case strings.HasPrefix(line, benchDoneMark):
+			// The pod is kept for fetch after this, don't wait for it.
+			fmt.Println(line)
+			return
+		case !inArtifact:
+			fmt.Println(line)
+		}
+	}
+	mustNil(scanner.Err())
+}
+
+// fetchBenchJobResults saves the result files of a finished bench job. They
+// are copied from the pod while it is kept, otherwise parsed from the logs.
+func (b *benchCtx) fetchBenchJobResults(run string) {
+	job, err := b.clientset.BatchV1().Jobs(b.tidbNamespace).Get(context.Background(), benchJobName(run), metav1.GetOptions{})
+	mustNil(err)
+	pod := b.getBenchJobPod(run)
+	err = os.MkdirAll(b.resultDir, 0755)
+	mustNil(err)
+	if pod.Status.Phase == apiv1.PodRunning {
+		status := &bytes.Buffer{}
+		err = b.execOnBenchJobPod(pod.Name, fmt.Sprintf("cat %s/%s 2>/dev/null || true", benchJobResultDir, benchJobDoneFile), status)
+		if err == nil && status.Len() == 0 {
+			log.Printf("Bench job %q is still running, try again later.\n", job.Name)
+			return
+		}
+		if err == nil {
+			if status.String() == "failed" {
+				log.Printf("Bench job %q failed, fetching partial results.\n", job.Name)
+			}
+			if err = b.copyBenchJobResults(pod.Name); err == nil {
+				return
+			}
+		}
+		log.Printf("Cannot copy results from pod %s: %v, fetching them from the logs.\n", pod.Name, err)
+	} else if job.Status.Failed > 0 {
+		log.Printf("Bench job %q failed, fetching partial results.\n", job.Name)
+	}
+	b.fetchBenchJobLogResults(run)
+}
+
+// copyBenchJobResults copies the result directory of the bench job pod like
+// kubectl cp, by reading a tar stream of it.
+func (b *benchCtx) copyBenchJobResults(pod string) error {
+	archive := &bytes.Buffer{}
+	err := b.execOnBenchJobPod(pod, fmt.Sprintf("tar cf - -C %s .", benchJobResultDir), archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || name == benchJobDoneFile {
+			continue
+		}
+		path := filepath.Join(b.resultDir, name)
+		f, err := os.Create(path)
+		mustNil(err)
+		_, err = io.Copy(f, tr)
+		mustNil(err)
+		mustNil(f.Close())
+		log.Printf("Fetch %s\n", path)
+	}
+}
+
+// execOnBenchJobPod runs the command on the bench job pod and writes its
+// stdout to w. Unlike execCmdOnPod it doesn't use a TTY, so binary output is
+// kept as is.
+func (b *benchCtx) execOnBenchJobPod(pod, command string, w io.Writer) error {
+	req := b.clientset.CoreV1().
+		RESTClient().
+		Post().
+		Namespace(b.tidbNamespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&apiv1.PodExecOptions{
+			Container: "bench",
+			Command:   []string{"sh", "-c", command},
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(b.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	stderr := &buffer{}
+	err = exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdout: w,
+		Stderr: stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("%w [%s]", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// fetchBenchJobLogResults saves the artifacts printed to the logs of a bench job.
+func (b *benchCtx) fetchBenchJobLogResults(run string) {
+	stream := b.benchJobLogs(run, false)
+	defer stream.Close()
+
+	var f *os.File
+	var err error
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, artifactBegin):
+			name := filepath.Base(strings.TrimSuffix(strings.TrimPrefix(line, artifactBegin), " ==="))
+			path := filepath.Join(b.resultDir, name)
+			f, err = os.Create(path)
+			mustNil(err)
+			log.Printf("Fetch %s\n", path)
+		case line == artifactEnd:
+			if f != nil {
+				mustNil(f.Close())
+				f = nil
+			}
+		case f != nil:
+			_, err = fmt.Fprintln(f, line)
+			mustNil(err)
+		}
+	}
+	mustNil(scanner.Err())
+	if f != nil {
+		mustNil(f.Close())
+	}
+}
+
+// printArtifacts writes the result files of a run to stdout, so they can be
+// fetched from the job logs.
+func printArtifacts(dir, id string) {
+	paths, err := filepath.Glob(filepath.Join(dir, id+"*"))
+	mustNil(err)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		mustNil(err)
+		fmt.Printf("%s%s ===\n", artifactBegin, filepath.Base(path))
+		fmt.Println(strings.TrimRight(string(data), "\n"))
+		fmt.Println(artifactEnd)
+	}
+}
+
+func execCmdLocally(command string) string {
+	out, err := exec.Command("sh", "-c", command).CombinedOutput()
+	status := "✔"
+	if err != nil {
+		status = "✘"
+	}
+	log.Printf("exec command: %s %s", command, status)
+	if err != nil {
+		log.Printf("meet exec error: %s [%s]\n", err.Error(), strings.Trim(string(out), "\r\n"))
+	}
+	return string(out)
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+// benchMatrix describes a parameter sweep. Every combination of the listed
+// values is benchmarked once, for example:
+//
+//	tables: [1, 8]
+//	rows: [100000]
+//	sysvars:
+//	  tidb_ddl_reorg_worker_cnt: [4, 16]
+//	  tidb_enable_dist_task: ["on", "off"]
+//	meta:
+//	  max-ingest-per-sec: [0, 104857600]
+//	meta_config: ./tidb.toml
+//	meta_path: 127.0.0.1:2379
+type benchMatrix struct {
+	Tables     []int                    `json:"tables"`
+	Rows       []int                    `json:"rows"`
+	SysVars    map[string][]interface{} `json:"sysvars"`
+	Meta       map[string][]interface{} `json:"meta"`
+	MetaConfig string                   `json:"meta_config"`
+	MetaStore  string                   `json:"meta_store"`
+	MetaPath   string                   `json:"meta_path"`
+	MetaPD     string                   `json:"meta_pd"`
+}
+
+// sweepDim is one axis of the sweep, named like "sysvar/tidb_enable_dist_task".
+type sweepDim struct {
+	name   string
+	values []string
+}
+
+func loadBenchMatrix(path string) *benchMatrix {
+	data, err := os.ReadFile(path)
+	mustNil(err)
+	m := &benchMatrix{}
+	err = yaml.UnmarshalStrict(data, m, func(d *json.Decoder) *json.Decoder {
+		d.UseNumber()
+		return d
+	})
+	mustNil(err)
+	if len(m.Meta) > 0 && m.MetaPD == "" && m.MetaPath == "" {
+		panic("meta_pd or meta_path is required to sweep meta keys")
+	}
+	if m.MetaStore == "" {
+		m.MetaStore = "tikv"
+	}
+	return m
+}
+
+func (m *benchMatrix) dims(b *benchCtx) []sweepDim {
+	intValues := func(vs []int, dft int) []string {
+		if len(vs) == 0 {
+			return []string{strconv.Itoa(dft)}
+		}
+		ret := make([]string, 0, len(vs))
+		for _, v := range vs {
+			ret = append(ret, strconv.Itoa(v))
+		}
+		return ret
+	}
+	dims := []sweepDim{
+		{name: "tables", values: intValues(m.Tables, b.tableCountInt)},
+		{name: "rows", values: intValues(m.Rows, b.rowCountInt)},
+	}
+	for _, name := range sortedKeys(m.SysVars) {
+		dims = append(dims, sweepDim{name: "sysvar/" + name, values: sweepValues(m.SysVars[name])})
+	}
+	for _, name := range sortedKeys(m.Meta) {
+		dims = append(dims, sweepDim{name: "meta/" + name, values: sweepValues(m.Meta[name])})
+	}
+	return dims
+}
+
+func sweepValues(vs []interface{}) []string {
+	ret := make([]string, 0, len(vs))
+	for _, v := range vs {
+		switch x := v.(type) {
+		case bool:
+			// YAML turns on/off into booleans.
+			if x {
+				ret = append(ret, "ON")
+			} else {
+				ret = append(ret, "OFF")
+			}
+		default:
+			ret = append(ret, fmt.Sprint(x))
+		}
+	}
+	return ret
+}
+
+// combinations returns the cartesian product of all dimensions.
+func combinations(dims []sweepDim) []map[string]string {
+	combs := []map[string]string{{}}
+	for _, d := range dims {
+		next := make([]map[string]string, 0, len(combs)*len(d.values))
+		for _, c := range combs {
+			for _, v := range d.values {
+				nc := make(map[string]string, len(c)+1)
+				for k, cv := range c {
+					nc[k] = cv
+				}
+				nc[d.name] = v
+				next = append(next, nc)
+			}
+		}
+		combs = next
+	}
+	return combs
+}
+
+func (b *benchCtx) runMatrix() {
+	m := loadBenchMatrix(b.matrixPath)
+	dims := m.dims(b)
+	combs := combinations(dims)
+	log.Printf("Sweep %d combination(s) from %s\n", len(combs), b.matrixPath)
+
+	origin := b.currentSysVars(sortedKeys(m.SysVars))
+	originMeta := m.currentMetaValues(sortedKeys(m.Meta))
+	defer func() {
+		b.applySysVars(origin)
+		for _, name := range sortedKeys(originMeta) {
+			m.writeMeta(name, originMeta[name])
+		}
+	}()
+
+	results := make([]*benchResult, 0, len(combs))
+	for i, comb := range combs {
+		log.Printf("Run combination %d/%d: %v\n", i+1, len(combs), comb)
+		b.applyCombination(m, comb)
+		results = append(results, b.runBench(comb))
+	}
+	printMatrixSummary(dims, results)
+}
+
+func (b *benchCtx) applyCombination(m *benchMatrix, comb map[string]string) {
+	b.tableCount = comb["tables"]
+	b.rowCount = comb["rows"]
+	b.validateAndFillArgs()
+
+	sysVars := make(map[string]string)
+	for k, v := range comb {
+		if name, ok := strings.CutPrefix(k, "sysvar/"); ok {
+			sysVars[name] = v
+		}
+	}
+	b.applySysVars(sysVars)
+
+	for _, k := range sortedKeys(comb) {
+		if name, ok := strings.CutPrefix(k, "meta/"); ok {
+			m.writeMeta(name, comb[k])
+		}
+	}
+}
+
+func (m *benchMatrix) metaCliCtx(opType, key, value string) *metaCliCtx {
+	return &metaCliCtx{
+		configPath: m.MetaConfig,
+		store:      m.MetaStore,
+		path:       m.MetaPath,
+		pd:         m.MetaPD,
+		opType:     opType,
+		key:        key,
+		value:      value,
+		yes:        true,
+		backupDir:  defaultMetaBackupDir,
+		auditLog:   defaultMetaAuditLog,
+	}
+}
+
+// currentMetaValues reads the meta keys before the sweep, so they can be restored after it.
+func (m *benchMatrix) currentMetaValues(names []string) map[string]string {
+	ret := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return ret
+	}
+	writes := make([]*metaWrite, 0, len(names))
+	for _, name := range names {
+		mCtx := m.metaCliCtx("get", name, "")
+		if !metaCliConfigIsValid(mCtx) {
+			panic(fmt.Sprintf("invalid meta key %s", name))
+		}
+		writes = append(writes, mCtx.write)
+	}
+	store := openMetaStore(m.metaCliCtx("get", names[0], ""))
+	defer store.Close()
+	for i, v := range readMetaKeys(store, writes) {
+		ret[names[i]] = v
+	}
+	return ret
+}
+
+// writeMeta puts the value of a meta key, metaNullValue deletes it.
+func (m *benchMatrix) writeMeta(name, value string) {
+	mCtx := m.metaCliCtx("put", name, value)
+	if value == metaNullValue {
+		mCtx = m.metaCliCtx("delete", name, "")
+	}
+	if !metaCliConfigIsValid(mCtx) {
+		panic(fmt.Sprintf("invalid meta setting %s=%s", name, value))
+	}
+	runMetaKVChange(mCtx)
+}
+
+func (b *benchCtx) currentSysVars(names []string) map[string]string {
+	ret := make(map[string]string, len(names))
+	for _, name := range names {
+		out := b.executeSQL(fmt.Sprintf("select @@global.%s;", name))
+		ret[name] = extractSQLResult(out, 0, 0)[0]
+	}
+	return ret
+}
+
+func (b *benchCtx) applySysVars(vars map[string]string) {
+	for _, name := range sortedKeys(vars) {
+		ret := b.executeSQL(fmt.Sprintf("set global %s = \"%s\";", name, vars[name]))
+		fmt.Print(ret)
+	}
+}
+
+func printMatrixSummary(dims []sweepDim, results []*benchResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := make([]string, 0, len(dims)+3)
+	for _, d := range dims {
+		header = append(header, strings.ToUpper(d.name))
+	}
+	header = append(header, "CREATE-INDEXES(s)", "RUN")
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+	for _, r := range results {
+		row := make([]string, 0, len(header))
+		for _, d := range dims {
+			row = append(row, r.Labels[d.name])
+		}
+		row = append(row, fmt.Sprintf("%.3f", r.Phases["create-indexes"]), r.ID)
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+	}
+	w.Flush()
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Phases["create-indexes"] < results[j].Phases["create-indexes"]
+	})
+	if len(results) > 0 {
+		fmt.Printf("Fastest run: %s %v\n", results[0].ID, results[0].Labels)
+	}
+}
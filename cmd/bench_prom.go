@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// benchPromQueries are the range queries exported for every bench run.
+var benchPromQueries = []struct {
+	name  string
+	query string
+}{
+	{"tidb-cpu", `sum(rate(process_cpu_seconds_total{job=~".*tidb"}[1m])) by (instance)`},
+	{"tikv-cpu", `sum(rate(tikv_thread_cpu_seconds_total[1m])) by (instance)`},
+	{"memory", `sum(process_resident_memory_bytes{job=~".*(tidb|tikv|pd)"}) by (job, instance)`},
+	{"tikv-write-flow", `sum(rate(tikv_engine_flow_bytes{db="kv", type="wal_file_bytes"}[1m])) by (instance)`},
+	{"region-split", `sum(increase(tikv_raftstore_admin_cmd_total{type=~".*split", status="success"}[1m]))`},
+	{"ddl-add-index-rate", `sum(rate(tidb_ddl_add_index_total[1m])) by (type)`},
+	{"ddl-backfill-progress", `max(tidb_ddl_backfill_percentage_progress) by (type)`},
+	{"ddl-handle-job-p99", `histogram_quantile(0.99, sum(rate(tidb_ddl_handle_job_duration_seconds_bucket[1m])) by (le, type))`},
+}
+
+// metricSeries is one time series returned by a range query.
+type metricSeries struct {
+	Name    string            `json:"name"`
+	Query   string            `json:"query"`
+	Labels  map[string]string `json:"labels"`
+	Samples []metricSample    `json:"samples"`
+}
+
+type metricSample struct {
+	Time  float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// promResponse is the body of the Prometheus HTTP API for range queries.
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Data   struct {
+		ResultType string       `json:"resultType"`
+		Result     []promMatrix `json:"result"`
+	} `json:"data"`
+}
+
+// promMatrix is a series in a range query result, values are [time, "value"] pairs.
+type promMatrix struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// promGet fetches a Prometheus API path. It queries the address given by
+// --prometheus directly, or the in-cluster Prometheus service from the bench pod.
+func (b *benchCtx) promGet(path string, params url.Values) ([]byte, error) {
+	if b.promAddr != "" {
+		addr := b.promAddr
+		if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			addr = "http://" + addr
+		}
+		resp, err := http.Get(addr + path + "?" + params.Encode())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+	cmd := fmt.Sprintf("curl -s 'http://%s:9090%s?%s'", b.promSVC, path, params.Encode())
+	return []byte(strings.TrimSpace(b.execCmdOnPod(cmd))), nil
+}
+
+func (b *benchCtx) detectPrometheus() bool {
+	if b.promAddr != "" {
+		return true
+	}
+	if b.clientset == nil {
+		log.Println("No Prometheus specified, skip collecting metrics.")
+		return false
+	}
+	svcList, err := b.clientset.CoreV1().Services(b.tidbNamespace).List(context.Background(), metav1.ListOptions{})
+	mustNil(err)
+	for _, item := range svcList.Items {
+		if strings.HasSuffix(item.Name, "-prometheus") {
+			b.promSVC = item.Name
+			log.Printf("Get Prometheus service name: %s\n", b.promSVC)
+			return true
+		}
+	}
+	log.Println("No Prometheus found, skip collecting metrics.")
+	return false
+}
+
+func (b *benchCtx) queryRange(query string, start, end time.Time) ([]metricSeries, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(b.promStep.Seconds(), 'f', -1, 64))
+	body, err := b.promGet("/api/v1/query_range", params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &promResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("invalid prometheus response: %w [%s]", err, string(body))
+	}
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("prometheus query %q failed: %s", query, resp.Error)
+	}
+	ret := make([]metricSeries, 0, len(resp.Data.Result))
+	for _, r := range resp.Data.Result {
+		s := metricSeries{Query: query, Labels: r.Metric}
+		for _, v := range r.Values {
+			if len(v) != 2 {
+				continue
+			}
+			ts, _ := v[0].(float64)
+			str, _ := v[1].(string)
+			val, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				continue
+			}
+			s.Samples = append(s.Samples, metricSample{Time: ts, Value: val})
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// collectMetrics exports the monitor metrics over the window of a bench result.
+// A failed query doesn't abort the run, it is recorded in the result instead,
+// and a later successful export clears it.
+func (b *benchCtx) collectMetrics(r *benchResult) {
+	if !b.detectPrometheus() {
+		return
+	}
+	var all []metricSeries
+	for _, q := range benchPromQueries {
+		series, err := b.queryRange(q.query, r.StartTime, r.EndTime)
+		if err != nil {
+			log.Printf("Cannot collect metrics: %v\n", err)
+			r.MetricsError = fmt.Sprintf("metrics unavailable: %v", err)
+			writeBenchResult(b.resultDir, r)
+			return
+		}
+		for i := range series {
+			series[i].Name = q.name
+		}
+		all = append(all, series...)
+	}
+	base := filepath.Join(b.resultDir, r.ID+".metrics")
+	saveMetricsJSON(base+".json", all)
+	saveMetricsCSV(base+".csv", all)
+	log.Printf("Saved %d metric series to %s.{json,csv}\n", len(all), base)
+	if r.MetricsError != "" {
+		r.MetricsError = ""
+		writeBenchResult(b.resultDir, r)
+	}
+}
+
+func saveMetricsJSON(path string, series []metricSeries) {
+	data, err := json.MarshalIndent(series, "", "  ")
+	mustNil(err)
+	err = os.WriteFile(path, data, 0644)
+	mustNil(err)
+}
+
+func saveMetricsCSV(path string, series []metricSeries) {
+	f, err := os.Create(path)
+	mustNil(err)
+	defer f.Close()
+	w := csv.NewWriter(f)
+	err = w.Write([]string{"name", "labels", "time", "value"})
+	mustNil(err)
+	for _, s := range series {
+		labels := make([]string, 0, len(s.Labels))
+		for _, k := range sortedKeys(s.Labels) {
+			labels = append(labels, fmt.Sprintf("%s=%s", k, s.Labels[k]))
+		}
+		for _, sample := range s.Samples {
+			err = w.Write([]string{
+				s.Name,
+				strings.Join(labels, ","),
+				time.Unix(int64(sample.Time), 0).UTC().Format(time.RFC3339),
+				strconv.FormatFloat(sample.Value, 'f', -1, 64),
+			})
+			mustNil(err)
+		}
+	}
+	w.Flush()
+	mustNil(w.Error())
+}
+
+// newStubPrometheus serves saved metric series through the range query API,
+// so the export can be exercised without a monitor.
+func newStubPrometheus(series []metricSeries) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query_range", func(w http.ResponseWriter, req *http.Request) {
+		query := req.FormValue("query")
+		start, _ := strconv.ParseFloat(req.FormValue("start"), 64)
+		end, _ := strconv.ParseFloat(req.FormValue("end"), 64)
+		resp := &promResponse{Status: "success"}
+		resp.Data.ResultType = "matrix"
+		for _, s := range series {
+			if s.Query != query {
+				continue
+			}
+			values := make([][]interface{}, 0, len(s.Samples))
+			for _, sample := range s.Samples {
+				if sample.Time < start || sample.Time > end {
+					continue
+				}
+				values = append(values, []interface{}{sample.Time, strconv.FormatFloat(sample.Value, 'f', -1, 64)})
+			}
+			resp.Data.Result = append(resp.Data.Result, promMatrix{Metric: s.Labels, Values: values})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(resp)
+		mustNil(err)
+	})
+	return mux
+}
+
+func runPromStub(path, listen string) {
+	var series []metricSeries
+	if path != "" {
+		data, err := os.ReadFile(path)
+		mustNil(err)
+		err = json.Unmarshal(data, &series)
+		mustNil(err)
+	}
+	log.Printf("Serve %d metric series on %s\n", len(series), listen)
+	err := http.ListenAndServe(listen, newStubPrometheus(series))
+	mustNil(err)
+}
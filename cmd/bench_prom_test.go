@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryRangeFromStubPrometheus(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	series := []metricSeries{
+		{
+			Query:  benchPromQueries[0].query,
+			Labels: map[string]string{"instance": "tidb-0"},
+			Samples: []metricSample{
+				{Time: float64(start.Unix()) - 10, Value: 1},
+				{Time: float64(start.Unix()), Value: 2.5},
+				{Time: float64(start.Unix()) + 15, Value: 3},
+			},
+		},
+		{Query: "other", Labels: map[string]string{"instance": "tikv-0"}, Samples: []metricSample{{Time: float64(start.Unix()), Value: 4}}},
+	}
+	srv := httptest.NewServer(newStubPrometheus(series))
+	defer srv.Close()
+
+	b := &benchCtx{promAddr: srv.URL, promStep: 500 * time.Millisecond}
+	got, err := b.queryRange(benchPromQueries[0].query, start, start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []metricSeries{{
+		Query:   benchPromQueries[0].query,
+		Labels:  map[string]string{"instance": "tidb-0"},
+		Samples: []metricSample{{Time: float64(start.Unix()), Value: 2.5}, {Time: float64(start.Unix()) + 15, Value: 3}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCollectMetricsRecordsPrometheusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("step") != "0.5" {
+			t.Errorf("unexpected step %q", req.FormValue("step"))
+		}
+		w.Write([]byte(`{"status":"error","error":"query timed out"}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	b := &benchCtx{promAddr: srv.URL, promStep: 500 * time.Millisecond, resultDir: dir}
+	r := &benchResult{ID: "run", StartTime: time.Unix(1700000000, 0), EndTime: time.Unix(1700000060, 0)}
+	b.collectMetrics(r)
+	if !strings.Contains(r.MetricsError, "query timed out") {
+		t.Fatalf("unexpected metrics error %q", r.MetricsError)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &benchResult{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.MetricsError != r.MetricsError {
+		t.Fatalf("the saved result has metrics error %q", saved.MetricsError)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run.metrics.json")); !os.IsNotExist(err) {
+		t.Fatalf("metrics should not be saved: %v", err)
+	}
+
+	// A later successful export clears the error in the saved result.
+	ok := httptest.NewServer(newStubPrometheus(nil))
+	defer ok.Close()
+	b.promAddr = ok.URL
+	b.collectMetrics(r)
+	if r.MetricsError != "" {
+		t.Fatalf("the metrics error %q is not cleared", r.MetricsError)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved = &benchResult{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.MetricsError != "" {
+		t.Fatalf("the saved result still has metrics error %q", saved.MetricsError)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run.metrics.json")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// benchSysVars are the global variables recorded with every bench result.
+var benchSysVars = []string{
+	"tidb_ddl_reorg_worker_cnt",
+	"tidb_ddl_reorg_batch_size",
+	"tidb_ddl_enable_fast_reorg",
+	"tidb_enable_dist_task",
+	"tidb_ddl_disk_quota",
+}
+
+var tidbClusterGVR = schema.GroupVersionResource{Group: "pingcap.com", Version: "v1alpha1", Resource: "tidbclusters"}
+
+// benchResult is the record persisted for each bench run.
+type benchResult struct {
+	ID          string             `json:"id"`
+	StartTime   time.Time          `json:"start_time"`
+	EndTime     time.Time          `json:"end_time"`
+	Version     string             `json:"version"`
+	GitHash     string             `json:"git_hash"`
+	ClusterSpec json.RawMessage    `json:"cluster_spec,omitempty"`
+	Dataset     string             `json:"dataset"`
+	Tables      int                `json:"tables"`
+	Rows        int                `json:"rows"`
+	SysVars     map[string]string  `json:"sysvars"`
+	DDL         []ddlTiming        `json:"ddl"`
+	Phases      map[string]float64 `json:"phases"`
+	Labels      map[string]string  `json:"labels,omitempty"`
+	// MetricsError is why the monitor metrics of the run are not exported.
+	MetricsError string `json:"metrics_error,omitempty"`
+}
+
+// ddlTiming is the wall-clock time of a single DDL statement, in seconds.
+type ddlTiming struct {
+	Table   string             `json:"table"`
+	SQL     string             `json:"sql"`
+	Seconds float64            `json:"seconds"`
+	JobID   string             `json:"job_id,omitempty"`
+	Phases  map[string]float64 `json:"phases,omitempty"`
+}
+
+func newBenchResult(b *benchCtx) *benchResult {
+	now := time.Now()
+	return &benchResult{
+		ID:        now.Format("20060102-150405"),
+		StartTime: now,
+		Dataset:   b.dataset,
+		Tables:    b.tableCountInt,
+		Rows:      b.rowCountInt,
+		SysVars:   make(map[string]string),
+		Phases:    make(map[string]float64),
+	}
+}
+
+func (r *benchResult) addPhase(name string, start time.Time) {
+	r.Phases[name] += time.Since(start).Seconds()
+}
+
+// collectClusterInfo fills the version, sysvars and TidbCluster spec of the result.
+func (b *benchCtx) collectClusterInfo() {
+	ret := b.executeSQL("select tidb_version();")
+	b.result.Version = extractLineValue(ret, "Release Version:")
+	b.result.GitHash = extractLineValue(ret, "Git Commit Hash:")
+	log.Printf("TiDB version: %s, git hash: %s\n", b.result.Version, b.result.GitHash)
+
+	quoted := make([]string, 0, len(benchSysVars))
+	for _, v := range benchSysVars {
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", v))
+	}
+	ret = b.executeSQL(fmt.Sprintf("show global variables where variable_name in (%s);", strings.Join(quoted, ",")))
+	for _, row := range extractSQLRows(ret, 0, 1) {
+		b.result.SysVars[row[0]] = row[1]
+	}
+
+	if b.config == nil {
+		return
+	}
+	dynCli, err := dynamic.NewForConfig(b.config)
+	if err != nil {
+		log.Printf("Skip collecting TidbCluster spec: %s\n", err.Error())
+		return
+	}
+	tcs, err := dynCli.Resource(tidbClusterGVR).Namespace(b.tidbNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(tcs.Items) == 0 {
+		log.Printf("Skip collecting TidbCluster spec: %v\n", err)
+		return
+	}
+	spec, err := json.Marshal(tcs.Items[0].Object["spec"])
+	mustNil(err)
+	b.result.ClusterSpec = spec
+}
+
+func (b *benchCtx) saveResult() {
+	b.result.EndTime = time.Now()
+	writeBenchResult(b.resultDir, b.result)
+}
+
+func writeBenchResult(dir string, r *benchResult) {
+	err := os.MkdirAll(dir, 0755)
+	mustNil(err)
+	data, err := json.MarshalIndent(r, "", "  ")
+	mustNil(err)
+	path := filepath.Join(dir, r.ID+".json")
+	err = os.WriteFile(path, data, 0644)
+	mustNil(err)
+	log.Printf("Saved bench result to %s\n", path)
+}
+
+// loadBenchResult loads a result by file path or by run ID in the result directory.
+func loadBenchResult(dir, ref string) *benchResult {
+	path := ref
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Join(dir, ref+".json")
+	}
+	data, err := os.ReadFile(path)
+	mustNil(err)
+	r := &benchResult{}
+	err = json.Unmarshal(data, r)
+	mustNil(err)
+	return r
+}
+
+// metrics flattens the timings of a result into named values in seconds.
+// DDL timings are keyed by statement order so that several DDLs on the same
+// table don't overwrite each other.
+func (r *benchResult) metrics() map[string]float64 {
+	m := make(map[string]float64, len(r.Phases)+len(r.DDL))
+	for name, v := range r.Phases {
+		m["phase/"+name] = v
+	}
+	for i, t := range r.DDL {
+		m[fmt.Sprintf("ddl/%03d/%s", i+1, t.Table)] = t.Seconds
+	}
+	for name, v := range r.statMetrics() {
+		m[name] = v
+	}
+	return m
+}
+
+func (b *benchCtx) compareResults(refA, refB string) {
+	a := loadBenchResult(b.resultDir, refA)
+	c := loadBenchResult(b.resultDir, refB)
+	fmt.Printf("A: %s (version %s, %s)\n", a.ID, a.Version, a.GitHash)
+	fmt.Printf("B: %s (version %s, %s)\n", c.ID, c.Version, c.GitHash)
+	for _, name := range sortedKeys(a.SysVars, c.SysVars) {
+		if a.SysVars[name] != c.SysVars[name] {
+			fmt.Printf("sysvar %s: %s -> %s\n", name, a.SysVars[name], c.SysVars[name])
+		}
+	}
+	if a.Tables != c.Tables || a.Rows != c.Rows {
+		fmt.Printf("dataset: %d tables x %d rows -> %d tables x %d rows\n", a.Tables, a.Rows, c.Tables, c.Rows)
+	}
+
+	ma, mb := a.metrics(), c.metrics()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tA(s)\tB(s)\tDELTA\t")
+	regressions := 0
+	for _, name := range sortedKeys(ma, mb) {
+		va, okA := ma[name]
+		vb, okB := mb[name]
+		if !okA || !okB {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t\n", name, formatOptional(va, okA), formatOptional(vb, okB))
+			continue
+		}
+		delta := 0.0
+		if va != 0 {
+			delta = (vb - va) / va
+		}
+		flag := ""
+		if delta > b.threshold {
+			flag = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%+.1f%%\t%s\n", name, va, vb, delta*100, flag)
+	}
+	w.Flush()
+	if regressions > 0 {
+		fmt.Printf("%d metric(s) regressed by more than %.1f%%\n", regressions, b.threshold*100)
+	}
+}
+
+func formatOptional(v float64, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f", v)
+}
+
+func sortedKeys[V any](ms ...map[string]V) []string {
+	seen := make(map[string]struct{})
+	for _, m := range ms {
+		for k := range m {
+			seen[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// extractLineValue returns the text after prefix on the first line containing it.
+func extractLineValue(result, prefix string) string {
+	for _, line := range strings.Split(result, "\n") {
+		idx := strings.Index(line, prefix)
+		if idx < 0 {
+			continue
+		}
+		return strings.Trim(line[idx+len(prefix):], " |\r")
+	}
+	return ""
+}
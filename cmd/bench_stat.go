@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+)
+
+// collectJobStat fills the job ID and per-phase timing of a finished DDL. The
+// timing is built from the history job on the TiDB status port, and the
+// backfill subtasks if the job runs as a distributed task.
+func (b *benchCtx) collectJobStat(t *ddlTiming) {
+	// The limit applies before the filter, and the indexes of all tables are created concurrently.
+	ret := b.executeSQLInTestDB(fmt.Sprintf("admin show ddl jobs %d where table_name = \"%s\";", b.tableCountInt+10, t.Table))
+	rows := extractSQLRows(ret, 0)
+	if len(rows) == 0 {
+		log.Printf("No DDL job found for table %s\n", t.Table)
+		return
+	}
+	t.JobID = rows[0][0]
+
+	data := b.execCmdOnPod(fmt.Sprintf("curl -s 'http://%s:10080%s'", b.tidbSVC, historyJobPath(t.JobID)))
+	job, err := parseHistoryJob([]byte(data), t.JobID)
+	if err != nil {
+		log.Printf("Cannot get the history of job %s: %v\n", t.JobID, err)
+		return
+	}
+	steps := extractSQLRows(b.executeSQL(subtaskStepsSQL(t.JobID)), 0, 1)
+	blob, err := jobStatBlob(job, steps)
+	if err != nil {
+		log.Printf("Cannot build stat of job %s: %v\n", t.JobID, err)
+		return
+	}
+	stat, err := decodeStat(blob, defaultStatMappings, "")
+	if err != nil {
+		log.Printf("Cannot decode stat of job %s: %v [%s]\n", t.JobID, err, blob)
+		return
+	}
+	t.Phases = stat.phases()
+}
+
+// statMetrics sums the phases of all DDL jobs in a result.
+func (r *benchResult) statMetrics() map[string]float64 {
+	m := make(map[string]float64)
+	for _, t := range r.DDL {
+		for phase, v := range t.Phases {
+			m["stat/"+phase] += v
+		}
+	}
+	return m
+}
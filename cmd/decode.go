@@ -1,95 +1,197 @@
 package cmd
 
 import (
-	"encoding/hex"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"os"
 
 	"github.com/spf13/cobra"
 )
 
-// decodeCmd represents the decode command
-var decodeCmd = &cobra.Command{
-	Use: "decode",
-	Run: runDecodeCmd,
+type decodeCtx struct {
+	jsonOutput bool
+	dsn        string
+	schemaPath string
+	dbName     string
+	filePath   string
+
+	statMappingPath string
+	statVersion     string
+	statMappings    []statMapping
+	jobIDs          []string
+	statusAddr      string
 }
 
 func init() {
+	ctx := &decodeCtx{}
+	// decodeCmd represents the decode command
+	var decodeCmd = &cobra.Command{
+		Use: "decode",
+		Run: runDecodeCmd(ctx),
+	}
 	rootCmd.AddCommand(decodeCmd)
+	decodeCmd.Flags().BoolVar(&ctx.jsonOutput, "json", false, "print the decoded keys in JSON")
+	decodeCmd.Flags().StringVar(&ctx.dsn, "dsn", "", "look up the tables and indexes in a live cluster, e.g. root@tcp(127.0.0.1:4000)/")
+	decodeCmd.Flags().StringVar(&ctx.schemaPath, "schema", "", "look up the tables and indexes in a schema dump saved by 'decode schema'")
+	decodeCmd.Flags().StringVarP(&ctx.filePath, "file", "f", "", "read keys from the file, one per line, - for stdin")
+	decodeCmd.Flags().StringVar(&ctx.statMappingPath, "stat-mapping", "", "the YAML file mapping stat keys to phases, see statMapping")
+	decodeCmd.Flags().StringVar(&ctx.statVersion, "stat-version", "", "use the stat mapping of the version instead of detecting it")
+	decodeCmd.Flags().StringSliceVar(&ctx.jobIDs, "jobs", nil, "build the stats of the finished DDL jobs from the DDL history of --status-addr, and their backfill subtasks if --dsn is set")
+	decodeCmd.Flags().StringVar(&ctx.statusAddr, "status-addr", "127.0.0.1:10080", "the TiDB status address to fetch the table schemas of --dsn and the stats of --jobs")
+	decodeCmd.Flags().StringVar(&ctx.dbName, "db", "", "only dump the tables of the database in 'decode schema'")
 }
 
-func runDecodeCmd(cmd *cobra.Command, args []string) {
-	if len(args) == 0 {
-		cmd.SetUsageFunc(func(c *cobra.Command) error {
-			fmt.Println("Usage: \n  decode key <hex string>, [<hex string>...] [flags]\n   decode stat <stat string>")
-			return nil
-		})
-		cmd.Usage()
-		return
-	}
-	switch args[0] {
-	case "key":
-		for _, arg := range args[1:] {
-			fmt.Printf("%s\n", decodeKey(arg))
+func runDecodeCmd(ctx *decodeCtx) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cmd.SetUsageFunc(func(c *cobra.Command) error {
+				fmt.Println("Usage: \n  decode key <key> [<key>...] [flags]\n  decode key -f <file|-> [flags]\n  decode row <key> <value> --dsn|--schema [flags]\n  decode schema --dsn <dsn> [--status-addr <addr>] [--db <db>] > schema.json\n  decode stat <stat string> [<stat string>...] [flags]\n  decode stat -f <file|-> [flags]\n  decode stat --jobs 101,102 [--status-addr <addr>] [--dsn <dsn>] [flags]")
+				return nil
+			})
+			cmd.Usage()
+			return
 		}
-	case "stat":
-		for _, arg := range args[1:] {
-			printDecodedStat(arg)
+		switch args[0] {
+		case "key":
+			inputs := args[1:]
+			if ctx.filePath != "" {
+				fromFile, err := readKeyInputs(ctx.filePath)
+				mustNil(err)
+				inputs = append(inputs, fromFile...)
+			} else if len(inputs) == 1 && inputs[0] == "-" {
+				fromStdin, err := readKeyInputs("-")
+				mustNil(err)
+				inputs = fromStdin
+			}
+			ctx.printDecodedKeys(inputs)
+		case "row":
+			if len(args) != 3 {
+				cmd.Usage()
+				return
+			}
+			ctx.printDecodedRow(args[1], args[2])
+		case "schema":
+			if ctx.dsn == "" {
+				cmd.Usage()
+				return
+			}
+			dumpKeySchema(ctx.dsn, ctx.statusAddr, ctx.dbName)
+		case "stat":
+			ctx.statMappings = loadStatMappings(ctx.statMappingPath)
+			ctx.printDecodedStats(args[1:])
 		}
 	}
 }
 
-func decodeKey(hexStr string) string {
-	v, err := hex.DecodeString(hexStr)
-	if err != nil {
-		panic(err)
+func (ctx *decodeCtx) printDecodedKeys(inputs []string) {
+	schema := ctx.keySchema()
+	keys := make([]*decodedKey, 0, len(inputs))
+	for _, input := range inputs {
+		k := decodeKey(input)
+		if schema != nil && k.Error == "" {
+			schema.annotate(k)
+		}
+		keys = append(keys, k)
 	}
-	sb := strings.Builder{}
-	sb.WriteByte(byte('['))
-	for i, r := range v {
-		sb.WriteString(fmt.Sprintf("%d", r))
-		if i < len(v)-1 {
-			sb.WriteByte(byte(','))
+	if ctx.jsonOutput {
+		if len(keys) == 1 {
+			printJSON(keys[0])
+		} else {
+			printJSON(keys)
 		}
+		return
+	}
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(k.String())
+	}
+}
+
+func (ctx *decodeCtx) printDecodedRow(keyInput, valueInput string) {
+	schema := ctx.keySchema()
+	if schema == nil {
+		mustNil(fmt.Errorf("decoding a row requires --dsn or --schema"))
+	}
+	key, _, err := parseKeyInput(keyInput)
+	mustNil(err)
+	value, _, err := parseKeyInput(valueInput)
+	mustNil(err)
+	row, err := schema.decodeRow(key, value)
+	mustNil(err)
+	if ctx.jsonOutput {
+		printJSON(row)
+		return
 	}
-	sb.WriteByte(byte(']'))
-	return sb.String()
+	fmt.Print(row.String())
+}
+
+// keySchema returns the schema to render the decoded keys, or nil if neither --dsn nor --schema is set.
+func (ctx *decodeCtx) keySchema() *keySchema {
+	return loadKeySchema(ctx.schemaPath, ctx.dsn, ctx.statusAddr)
 }
 
-func printDecodedStat(data string) {
-	var v interface{}
-	err := json.Unmarshal([]byte(data), &v)
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	mustNil(enc.Encode(v))
+}
+
+// decodeKey decodes a key in any supported input format. A key that cannot be
+// parsed is reported in the Error field instead of aborting a batch.
+func decodeKey(input string) *decodedKey {
+	v, format, err := parseKeyInput(input)
 	if err != nil {
-		panic(err)
+		return &decodedKey{Input: input, Error: err.Error()}
 	}
-	m := v.(map[string]interface{})
-	total := m["add-index-job"].(float64)
-	fmt.Printf("%.3f\n", total)
-	read := extractOneOfSum(m["op-scan-records-1"], m["scan-records-1"])
-	wait := extractOneOfSum(m["op-send-chunk-1"], m["send-chunk-1"])
-	write := extractOneOfSum(m["op-write-local-1"], m["write-local-1"])
-	fmt.Printf("%.3f [%.3f] / %.3f\n", read, wait, write)
-	ingest := extractOneOfSum(m["op-result-collect-flush"], m["finish-import-1"], m["finish-import-2"], m["finish-import-3"])
-	fmt.Printf("%.3f\n", ingest)
+	d := decodeTiDBKey(v)
+	d.Input, d.Format = input, format
+	return d
 }
 
-func extractOneOfSum(vs ...interface{}) float64 {
-	for _, v := range vs {
-		if v == nil {
-			continue
+// printDecodedStats prints a single stat in detail, and several stats side by side.
+func (ctx *decodeCtx) printDecodedStats(blobs []string) {
+	var stats []labeledStat
+	if len(ctx.jobIDs) > 0 {
+		var db *sql.DB
+		if ctx.dsn != "" {
+			var err error
+			db, err = sql.Open("mysql", ctx.dsn)
+			mustNil(err)
+			defer db.Close()
 		}
-		return extractSum(v)
+		stats = fetchJobStats(ctx.statusAddr, db, ctx.jobIDs)
 	}
-	panic(fmt.Sprintf("no sum found, tried: %v", vs))
-}
+	if ctx.filePath != "" {
+		fromFile, err := readStatBlobs(ctx.filePath)
+		mustNil(err)
+		blobs = append(blobs, fromFile...)
+	}
+	for i, blob := range blobs {
+		stats = append(stats, labeledStat{Label: fmt.Sprintf("#%d", i+1), blob: blob})
+	}
+	ctx.decodeLabeledStats(stats)
 
-func extractSum(v interface{}) float64 {
-	switch x := v.(type) {
-	case map[string]interface{}:
-		return x["sum"].(float64)
-	case float64:
-		return x
+	if len(stats) == 1 {
+		if stats[0].Error != "" {
+			fmt.Printf("Error: %s\n", stats[0].Error)
+			return
+		}
+		if ctx.jsonOutput {
+			printJSON(stats[0].Stat)
+			return
+		}
+		stats[0].Stat.print(os.Stdout)
+		return
+	}
+	if ctx.jsonOutput {
+		printJSON(map[string]interface{}{
+			"stats":   stats,
+			"summary": summarizeStats(stats),
+		})
+		return
 	}
-	panic(fmt.Sprintf("no sum found, tried: %v", v))
+	printStatsSideBySide(os.Stdout, stats)
 }
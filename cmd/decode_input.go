@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	keyInputHex     = "hex"
+	keyInputEscaped = "escaped"
+	keyInputBase64  = "base64"
+	keyInputRaw     = "raw"
+)
+
+// parseKeyInput detects the format of a key and returns its bytes. It accepts
+// hex in either case with an optional 0x prefix, the escaped strings in TiDB
+// and TiKV logs like t\200\000, base64 as in JSON, and raw strings.
+func parseKeyInput(s string) ([]byte, string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	if s == "" {
+		return nil, "", fmt.Errorf("empty key")
+	}
+	if h, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		b, err := hex.DecodeString(h)
+		return b, keyInputHex, err
+	}
+	if isHex(s) {
+		b, err := hex.DecodeString(s)
+		return b, keyInputHex, err
+	}
+	if strings.Contains(s, `\`) {
+		b, err := unescapeKey(s)
+		return b, keyInputEscaped, err
+	}
+	// A raw key like "mDDLJobList" may happen to be valid base64 as well, prefer
+	// the interpretation that looks like a TiDB key.
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil && (hasKeyPrefix(b) || !hasKeyPrefix([]byte(s))) {
+			return b, keyInputBase64, nil
+		}
+	}
+	return []byte(s), keyInputRaw, nil
+}
+
+// hasKeyPrefix reports whether b starts like a TiDB key, a keyspace key or a TiKV data key.
+func hasKeyPrefix(b []byte) bool {
+	return len(b) > 0 && strings.IndexByte("tmxz", b[0]) >= 0
+}
+
+func isHex(s string) bool {
+	if len(s)%2 != 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// unescapeKey is the inverse of escapeKey, it also accepts \xHH and the common C escapes.
+func unescapeKey(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, fmt.Errorf("trailing backslash at %d", i)
+		}
+		i++
+		switch c := s[i]; {
+		case c >= '0' && c <= '7':
+			end := i
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			v, err := strconv.ParseUint(s[i:end], 8, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid octal escape at %d: %w", i-1, err)
+			}
+			b = append(b, byte(v))
+			i = end - 1
+		case c == 'x':
+			if i+3 > len(s) {
+				return nil, fmt.Errorf("invalid hex escape at %d", i-1)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex escape at %d: %w", i-1, err)
+			}
+			b = append(b, byte(v))
+			i += 2
+		case c == 'n':
+			b = append(b, '\n')
+		case c == 'r':
+			b = append(b, '\r')
+		case c == 't':
+			b = append(b, '\t')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b, nil
+}
+
+// readKeyInputs reads one key per line from a file, or stdin if path is "-".
+// Blank lines are skipped.
+func readKeyInputs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/structure"
+	"github.com/pingcap/tidb/pkg/tablecodec"
+	"github.com/pingcap/tidb/pkg/types"
+	"github.com/pingcap/tidb/pkg/util/codec"
+)
+
+// decodedKey is the structured breakdown of a TiDB key.
+type decodedKey struct {
+	Input string `json:"input,omitempty"`
+	// Format is the detected format of Input.
+	Format string `json:"format,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Hex    string `json:"hex,omitempty"`
+	// Wrappers lists the encodings removed before decoding, outermost first.
+	Wrappers   []string `json:"wrappers,omitempty"`
+	KeyspaceID *uint32  `json:"keyspace_id,omitempty"`
+	CommitTS   *uint64  `json:"commit_ts,omitempty"`
+	Type       string   `json:"type,omitempty"`
+	TableID    int64    `json:"table_id,omitempty"`
+	IndexID    int64    `json:"index_id,omitempty"`
+	// DB, TableName, PartitionName, IndexName and HandleColumn are filled from the schema if given.
+	DB            string         `json:"db,omitempty"`
+	TableName     string         `json:"table_name,omitempty"`
+	PartitionName string         `json:"partition_name,omitempty"`
+	IndexName     string         `json:"index_name,omitempty"`
+	HandleColumn  string         `json:"handle_column,omitempty"`
+	TempIndex     bool           `json:"temp_index,omitempty"`
+	IntHandle     *int64         `json:"int_handle,omitempty"`
+	Values        []decodedDatum `json:"values,omitempty"`
+	MetaKey       string         `json:"meta_key,omitempty"`
+	MetaType      string         `json:"meta_type,omitempty"`
+	MetaField     string         `json:"meta_field,omitempty"`
+	// Remain is the hex of the trailing bytes that cannot be decoded, e.g. of a truncated region boundary.
+	Remain string `json:"remain,omitempty"`
+
+	datums []types.Datum
+}
+
+// decodedDatum is a codec-encoded value. Without the schema only the
+// storage kind is known, e.g. datetime is stored as unsigned bigint.
+type decodedDatum struct {
+	Column string `json:"column,omitempty"`
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	// SortKey means Value is the collation sort key of a string column, which
+	// is what keys store under the new collation, not the stored string.
+	SortKey bool `json:"sort_key,omitempty"`
+}
+
+const (
+	keyTypeRecord  = "record"
+	keyTypeIndex   = "index"
+	keyTypeTable   = "table"
+	keyTypeMeta    = "meta"
+	keyTypeUnknown = "unknown"
+)
+
+var metaTypeNames = map[structure.TypeFlag]string{
+	structure.StringMeta: "string-meta",
+	structure.StringData: "string",
+	structure.HashMeta:   "hash-meta",
+	structure.HashData:   "hash",
+	structure.ListMeta:   "list-meta",
+	structure.ListData:   "list",
+}
+
+// decodeTiDBKey decodes a key of TiDB, optionally wrapped by the TiKV data
+// prefix "z", the memcomparable encoding and a keyspace prefix.
+func decodeTiDBKey(key []byte) *decodedKey {
+	d := &decodedKey{Hex: strings.ToUpper(hex.EncodeToString(key)), Type: keyTypeUnknown}
+	key = d.unwrap(key)
+	switch {
+	case len(key) > 0 && key[0] == 't':
+		d.decodeTableKey(key)
+	case len(key) > 0 && key[0] == 'm':
+		d.decodeMetaKey(key)
+	default:
+		d.setRemain(key)
+	}
+	return d
+}
+
+func (d *decodedKey) unwrap(key []byte) []byte {
+	// TiKV stores keys as 'z' + memcomparable(key) + desc(ts) in its data column families.
+	if len(key) > 1 && key[0] == 'z' && key[1] != '_' {
+		if rest, raw, err := codec.DecodeBytes(key[1:], nil); err == nil {
+			d.Wrappers = append(d.Wrappers, "tikv-data-prefix", "memcomparable")
+			d.setTS(rest)
+			key = raw
+		}
+	} else if isMemcomparable(key) {
+		rest, raw, _ := codec.DecodeBytes(key, nil)
+		d.Wrappers = append(d.Wrappers, "memcomparable")
+		d.setTS(rest)
+		key = raw
+	}
+	// API v2 keys start with 'x' and a 3-byte keyspace ID.
+	if len(key) > 4 && key[0] == 'x' && (key[4] == 't' || key[4] == 'm') {
+		id := uint32(key[1])<<16 | uint32(key[2])<<8 | uint32(key[3])
+		d.KeyspaceID = &id
+		d.Wrappers = append(d.Wrappers, "keyspace")
+		key = key[4:]
+	}
+	return key
+}
+
+// isMemcomparable checks whether key is a complete memcomparable-encoded TiDB key.
+func isMemcomparable(key []byte) bool {
+	if len(key) < 9 || (key[0] != 't' && key[0] != 'm' && key[0] != 'x') {
+		return false
+	}
+	rest, raw, err := codec.DecodeBytes(key, nil)
+	if err != nil || (len(rest) != 0 && len(rest) != 8) {
+		return false
+	}
+	return len(raw) > 0 && raw[0] == key[0]
+}
+
+func (d *decodedKey) setTS(rest []byte) {
+	if len(rest) != 8 {
+		d.setRemain(rest)
+		return
+	}
+	ts := ^binary.BigEndian.Uint64(rest)
+	d.CommitTS = &ts
+}
+
+func (d *decodedKey) setRemain(rest []byte) {
+	if len(rest) > 0 {
+		d.Remain = strings.ToUpper(hex.EncodeToString(rest))
+	}
+}
+
+func (d *decodedKey) decodeTableKey(key []byte) {
+	rest, tableID, err := codec.DecodeInt(key[1:])
+	if err != nil {
+		d.setRemain(key)
+		return
+	}
+	d.Type = keyTypeTable
+	d.TableID = tableID
+	switch {
+	case bytes.HasPrefix(rest, []byte("_r")):
+		d.Type = keyTypeRecord
+		rest = rest[2:]
+		if len(rest) == 8 {
+			_, handle, err := codec.DecodeInt(rest)
+			if err == nil {
+				d.IntHandle = &handle
+				return
+			}
+		}
+		d.decodeValues(rest)
+	case bytes.HasPrefix(rest, []byte("_i")):
+		d.Type = keyTypeIndex
+		rest, indexID, err := codec.DecodeInt(rest[2:])
+		if err != nil {
+			d.setRemain(rest)
+			return
+		}
+		if indexID&tablecodec.TempIndexPrefix == tablecodec.TempIndexPrefix {
+			d.TempIndex = true
+			indexID &= tablecodec.IndexIDMask
+		}
+		d.IndexID = indexID
+		d.decodeValues(rest)
+	default:
+		d.setRemain(rest)
+	}
+}
+
+func (d *decodedKey) decodeValues(b []byte) {
+	for len(b) > 0 {
+		remain, datum, err := codec.DecodeOne(b)
+		if err != nil {
+			break
+		}
+		d.Values = append(d.Values, newDecodedDatum(datum))
+		d.datums = append(d.datums, datum)
+		b = remain
+	}
+	d.setRemain(b)
+}
+
+func newDecodedDatum(datum types.Datum) decodedDatum {
+	kind := types.KindStr(datum.Kind())
+	if datum.IsNull() {
+		return decodedDatum{Kind: kind, Value: "NULL"}
+	}
+	str, err := datum.ToString()
+	if err != nil {
+		str = err.Error()
+	}
+	if datum.Kind() == types.KindBytes || datum.Kind() == types.KindString {
+		str = strconv.Quote(str)
+	}
+	return decodedDatum{Kind: kind, Value: str}
+}
+
+func (d *decodedKey) decodeMetaKey(key []byte) {
+	rest, metaKey, err := codec.DecodeBytes(key[1:], nil)
+	if err != nil {
+		d.setRemain(key)
+		return
+	}
+	d.Type = keyTypeMeta
+	d.MetaKey = string(metaKey)
+	rest, tp, err := codec.DecodeUint(rest)
+	if err != nil {
+		d.setRemain(rest)
+		return
+	}
+	d.MetaType = metaTypeNames[structure.TypeFlag(tp)]
+	if d.MetaType == "" {
+		d.MetaType = fmt.Sprintf("unknown(%c)", byte(tp))
+	}
+	switch structure.TypeFlag(tp) {
+	case structure.HashData:
+		rest, field, err := codec.DecodeBytes(rest, nil)
+		if err != nil {
+			d.setRemain(rest)
+			return
+		}
+		d.MetaField = string(field)
+		d.setRemain(rest)
+	case structure.ListData:
+		rest, index, err := codec.DecodeInt(rest)
+		if err != nil {
+			d.setRemain(rest)
+			return
+		}
+		d.MetaField = strconv.FormatInt(index, 10)
+		d.setRemain(rest)
+	default:
+		d.setRemain(rest)
+	}
+}
+
+// Short returns the compact form, e.g. t{42}_i{3}{"abc", 17}.
+func (d *decodedKey) Short() string {
+	var sb strings.Builder
+	if d.KeyspaceID != nil {
+		fmt.Fprintf(&sb, "x{%d}", *d.KeyspaceID)
+	}
+	switch d.Type {
+	case keyTypeRecord, keyTypeIndex, keyTypeTable:
+		fmt.Fprintf(&sb, "t{%d}", d.TableID)
+		if d.Type == keyTypeRecord {
+			sb.WriteString("_r")
+		} else if d.Type == keyTypeIndex {
+			sb.WriteString("_i")
+			if d.TempIndex {
+				sb.WriteString("(temp)")
+			}
+			fmt.Fprintf(&sb, "{%d}", d.IndexID)
+		}
+		if d.IntHandle != nil {
+			fmt.Fprintf(&sb, "{%d}", *d.IntHandle)
+		}
+		if len(d.Values) > 0 {
+			vals := make([]string, 0, len(d.Values))
+			for _, v := range d.Values {
+				vals = append(vals, v.Value)
+			}
+			fmt.Fprintf(&sb, "{%s}", strings.Join(vals, ", "))
+		}
+	case keyTypeMeta:
+		fmt.Fprintf(&sb, "m{%s}{%s}", d.MetaKey, d.MetaType)
+		if d.MetaField != "" {
+			fmt.Fprintf(&sb, "{%s}", d.MetaField)
+		}
+	default:
+		sb.WriteString("?")
+	}
+	if d.Remain != "" {
+		fmt.Fprintf(&sb, "...%s", d.Remain)
+	}
+	return sb.String()
+}
+
+// String returns the labeled multi-line form.
+func (d *decodedKey) String() string {
+	var sb strings.Builder
+	if d.Error != "" {
+		fmt.Fprintf(&sb, "input:     %s\n", d.Input)
+		fmt.Fprintf(&sb, "error:     %s\n", d.Error)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "key:       %s\n", d.Hex)
+	if d.Format != "" && d.Format != keyInputHex {
+		fmt.Fprintf(&sb, "format:    %s\n", d.Format)
+	}
+	fmt.Fprintf(&sb, "decoded:   %s\n", d.Short())
+	if len(d.Wrappers) > 0 {
+		fmt.Fprintf(&sb, "wrappers:  %s\n", strings.Join(d.Wrappers, ", "))
+	}
+	if d.KeyspaceID != nil {
+		fmt.Fprintf(&sb, "keyspace:  %d\n", *d.KeyspaceID)
+	}
+	if d.CommitTS != nil {
+		fmt.Fprintf(&sb, "ts:        %d\n", *d.CommitTS)
+	}
+	fmt.Fprintf(&sb, "type:      %s\n", d.Type)
+	switch d.Type {
+	case keyTypeRecord, keyTypeIndex, keyTypeTable:
+		fmt.Fprintf(&sb, "table id:  %d", d.TableID)
+		if d.TableName != "" {
+			fmt.Fprintf(&sb, " (%s.%s)", d.DB, d.TableName)
+		}
+		if d.PartitionName != "" {
+			fmt.Fprintf(&sb, " partition %s", d.PartitionName)
+		}
+		sb.WriteString("\n")
+	case keyTypeMeta:
+		fmt.Fprintf(&sb, "meta key:  %s (%s)\n", d.MetaKey, d.MetaType)
+		if d.MetaField != "" {
+			fmt.Fprintf(&sb, "field:     %s\n", d.MetaField)
+		}
+	}
+	if d.Type == keyTypeIndex {
+		fmt.Fprintf(&sb, "index id:  %d", d.IndexID)
+		if d.IndexName != "" {
+			fmt.Fprintf(&sb, " (%s)", d.IndexName)
+		}
+		if d.TempIndex {
+			sb.WriteString(" (temp index)")
+		}
+		sb.WriteString("\n")
+	}
+	if d.IntHandle != nil {
+		fmt.Fprintf(&sb, "handle:    %d (int)", *d.IntHandle)
+		if d.HandleColumn != "" {
+			fmt.Fprintf(&sb, " %s", d.HandleColumn)
+		}
+		sb.WriteString("\n")
+	}
+	for i, v := range d.Values {
+		label := "value"
+		if d.Type == keyTypeRecord {
+			label = "handle"
+		}
+		kind := v.Kind
+		if v.SortKey {
+			kind += ", collation sort key"
+		}
+		if v.Column != "" {
+			fmt.Fprintf(&sb, "%-6s %2d: %s = %s (%s)\n", label, i, v.Column, v.Value, kind)
+			continue
+		}
+		fmt.Fprintf(&sb, "%-6s %2d: %s (%s)\n", label, i, v.Value, kind)
+	}
+	if d.Remain != "" {
+		fmt.Fprintf(&sb, "remain:    %s\n", d.Remain)
+	}
+	return sb.String()
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/tablecodec"
+	"github.com/pingcap/tidb/pkg/types"
+	"github.com/pingcap/tidb/pkg/util/codec"
+	"github.com/pingcap/tidb/pkg/util/collate"
+	"github.com/pingcap/tidb/pkg/util/rowcodec"
+	"github.com/tangenta/dbtool/util"
+)
+
+// schemaTable is a table definition used to render decoded keys and rows.
+type schemaTable struct {
+	DB    string           `json:"db"`
+	Table *model.TableInfo `json:"table"`
+}
+
+// keySchema resolves physical table IDs, including partition IDs, to table definitions.
+type keySchema struct {
+	tables map[int64]*schemaTable
+	// db and statusAddr are set when the schema is loaded lazily from a live cluster.
+	db         *sql.DB
+	statusAddr string
+}
+
+func newKeySchema(tables []*schemaTable) *keySchema {
+	s := &keySchema{tables: make(map[int64]*schemaTable)}
+	for _, t := range tables {
+		s.add(t)
+	}
+	return s
+}
+
+func (s *keySchema) add(t *schemaTable) {
+	s.tables[t.Table.ID] = t
+	if pi := t.Table.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			s.tables[def.ID] = t
+		}
+	}
+}
+
+// loadKeySchema loads the schema dump at schemaPath, or looks up the tables in
+// the cluster of dsn. It returns nil if neither is set.
+func loadKeySchema(schemaPath, dsn, statusAddr string) *keySchema {
+	switch {
+	case schemaPath != "":
+		return loadKeySchemaFile(schemaPath)
+	case dsn != "":
+		supressLogOutput()
+		return openKeySchemaDB(dsn, statusAddr)
+	}
+	return nil
+}
+
+// loadKeySchemaFile reads a schema dump written by "decode schema".
+func loadKeySchemaFile(path string) *keySchema {
+	data, err := os.ReadFile(path)
+	mustNil(err)
+	var tables []*schemaTable
+	err = json.Unmarshal(data, &tables)
+	mustNil(err)
+	return newKeySchema(tables)
+}
+
+func openKeySchemaDB(dsn, statusAddr string) *keySchema {
+	db, err := sql.Open("mysql", dsn)
+	mustNil(err)
+	s := newKeySchema(nil)
+	s.db, s.statusAddr = db, statusAddr
+	return s
+}
+
+// table returns the definition of the physical table ID, or nil if it is unknown.
+func (s *keySchema) table(id int64) *schemaTable {
+	if t, ok := s.tables[id]; ok {
+		return t
+	}
+	if s.db == nil {
+		return nil
+	}
+	rows, err := s.db.Query(`SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.tables WHERE TIDB_TABLE_ID = ?
+		UNION SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.partitions WHERE TIDB_PARTITION_ID = ?`, id, id)
+	mustNil(err)
+	names := util.ReadAll(rows)
+	if len(names) == 0 {
+		s.tables[id] = nil
+		return nil
+	}
+	t, err := fetchSchemaTable(s.statusAddr, names[0][0], names[0][1])
+	mustNil(err)
+	s.add(t)
+	return s.tables[id]
+}
+
+// fetchSchemaTable fetches the table definition from the TiDB status port.
+// Unlike SHOW CREATE TABLE, it has the real column IDs, which the row values
+// are keyed by.
+func fetchSchemaTable(statusAddr, dbName, tblName string) (*schemaTable, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	body, err := httpGet(ctx, statusURL(statusAddr)+"/schema/"+url.PathEscape(dbName)+"/"+url.PathEscape(tblName))
+	if err != nil {
+		return nil, err
+	}
+	tblInfo := &model.TableInfo{}
+	if err := json.Unmarshal(body, tblInfo); err != nil {
+		return nil, fmt.Errorf("invalid schema of %s.%s: %w", dbName, tblName, err)
+	}
+	return &schemaTable{DB: dbName, Table: tblInfo}, nil
+}
+
+// statusURL adds the scheme to a TiDB status address if it has none.
+func statusURL(addr string) string {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		return "http://" + addr
+	}
+	return addr
+}
+
+// dumpKeySchema prints the definitions of all user tables, or the tables of dbName.
+func dumpKeySchema(dsn, statusAddr, dbName string) {
+	supressLogOutput()
+	db, err := sql.Open("mysql", dsn)
+	mustNil(err)
+	defer db.Close()
+	query := "SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.tables WHERE TABLE_TYPE = 'BASE TABLE' " +
+		"AND TABLE_SCHEMA NOT IN ('mysql', 'INFORMATION_SCHEMA', 'PERFORMANCE_SCHEMA', 'METRICS_SCHEMA', 'sys')"
+	var queryArgs []any
+	if dbName != "" {
+		query += " AND TABLE_SCHEMA = ?"
+		queryArgs = append(queryArgs, dbName)
+	}
+	rows, err := db.Query(query+" ORDER BY TABLE_SCHEMA, TABLE_NAME", queryArgs...)
+	mustNil(err)
+	var tables []*schemaTable
+	for _, row := range util.ReadAll(rows) {
+		t, err := fetchSchemaTable(statusAddr, row[0], row[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skip %s.%s: %s\n", row[0], row[1], err.Error())
+			continue
+		}
+		tables = append(tables, t)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	mustNil(enc.Encode(tables))
+}
+
+// annotate names the table, index and values of a decoded key, and converts
+// the values to the column types. Strings in a non-binary collation are marked
+// as sort keys, since the key doesn't keep the original string.
+func (s *keySchema) annotate(d *decodedKey) {
+	if d.Type != keyTypeRecord && d.Type != keyTypeIndex && d.Type != keyTypeTable {
+		return
+	}
+	t := s.table(d.TableID)
+	if t == nil {
+		return
+	}
+	d.DB, d.TableName = t.DB, t.Table.Name.O
+	if t.Table.ID != d.TableID {
+		d.PartitionName = partitionName(t.Table, d.TableID)
+	}
+
+	var cols []*model.ColumnInfo
+	switch d.Type {
+	case keyTypeRecord:
+		if d.IntHandle != nil {
+			if pk := t.Table.GetPkColInfo(); pk != nil && t.Table.PKIsHandle {
+				d.HandleColumn = pk.Name.O
+			} else {
+				d.HandleColumn = model.ExtraHandleName.O
+			}
+			return
+		}
+		cols = handleColumns(t.Table)
+	case keyTypeIndex:
+		for _, idx := range t.Table.Indices {
+			if idx.ID != d.IndexID {
+				continue
+			}
+			d.IndexName = idx.Name.O
+			for _, ic := range idx.Columns {
+				cols = append(cols, t.Table.Columns[ic.Offset])
+			}
+			// Non-unique index keys end with the handle.
+			if len(d.datums) > len(cols) {
+				cols = append(cols, handleColumns(t.Table)...)
+			}
+		}
+	}
+	for i := range d.Values {
+		if i >= len(cols) {
+			break
+		}
+		d.Values[i].Column = cols[i].Name.O
+		v, err := tablecodec.Unflatten(d.datums[i], &cols[i].FieldType, time.UTC)
+		if err != nil {
+			continue
+		}
+		d.Values[i] = newDecodedDatum(v)
+		d.Values[i].Column = cols[i].Name.O
+		d.Values[i].Kind = cols[i].FieldType.CompactStr()
+		d.Values[i].SortKey = types.IsString(cols[i].GetType()) && !collate.IsBinCollation(cols[i].GetCollate())
+	}
+}
+
+func partitionName(tblInfo *model.TableInfo, pid int64) string {
+	if pi := tblInfo.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			if def.ID == pid {
+				return def.Name.O
+			}
+		}
+	}
+	return ""
+}
+
+// handleColumns returns the columns encoded in the handle. For an int handle
+// that is not a primary key, a nil column stands for _tidb_rowid.
+func handleColumns(tblInfo *model.TableInfo) []*model.ColumnInfo {
+	if tblInfo.IsCommonHandle {
+		var cols []*model.ColumnInfo
+		for _, ic := range tblInfo.GetPrimaryKey().Columns {
+			cols = append(cols, tblInfo.Columns[ic.Offset])
+		}
+		return cols
+	}
+	if pk := tblInfo.GetPkColInfo(); pk != nil && tblInfo.PKIsHandle {
+		return []*model.ColumnInfo{pk}
+	}
+	return []*model.ColumnInfo{model.NewExtraHandleColInfo()}
+}
+
+// decodedRow is a row value decoded against the table definition.
+type decodedRow struct {
+	Key     *decodedKey    `json:"key"`
+	Format  string         `json:"format"`
+	Columns []decodedDatum `json:"columns"`
+}
+
+// decodeRow decodes the value of a record key, in the new row format or the old one.
+func (s *keySchema) decodeRow(key, value []byte) (*decodedRow, error) {
+	d := decodeTiDBKey(key)
+	if d.Type != keyTypeRecord {
+		return nil, fmt.Errorf("%s is not a record key", d.Short())
+	}
+	s.annotate(d)
+	t := s.table(d.TableID)
+	if t == nil {
+		return nil, fmt.Errorf("table %d is not found in the schema", d.TableID)
+	}
+	row := &decodedRow{Key: d, Format: "v1"}
+	if rowcodec.IsNewFormat(value) {
+		row.Format = "v2"
+	}
+
+	fts := make(map[int64]*types.FieldType, len(t.Table.Columns))
+	for _, col := range t.Table.Columns {
+		fts[col.ID] = &col.FieldType
+	}
+	datums, err := tablecodec.DecodeRowToDatumMap(value, fts, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+	// The int handle is not stored in the row value.
+	if handle := d.handle(); handle != nil {
+		var handleIDs []int64
+		for _, col := range handleColumns(t.Table) {
+			handleIDs = append(handleIDs, col.ID)
+		}
+		datums, err = tablecodec.DecodeHandleToDatumMap(handle, handleIDs, fts, time.UTC, datums)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, col := range t.Table.Columns {
+		v, ok := datums[col.ID]
+		var dd decodedDatum
+		if ok {
+			dd = newDecodedDatum(v)
+		} else {
+			dd = decodedDatum{Value: "<default>"}
+		}
+		dd.Column = col.Name.O
+		dd.Kind = col.FieldType.CompactStr()
+		row.Columns = append(row.Columns, dd)
+	}
+	return row, nil
+}
+
+// handle rebuilds the handle of a record key.
+func (d *decodedKey) handle() kv.Handle {
+	if d.IntHandle != nil {
+		return kv.IntHandle(*d.IntHandle)
+	}
+	if len(d.datums) == 0 {
+		return nil
+	}
+	encoded, err := codec.EncodeKey(time.UTC, nil, d.datums...)
+	if err != nil {
+		return nil
+	}
+	h, err := kv.NewCommonHandle(encoded)
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+func (r *decodedRow) String() string {
+	var sb strings.Builder
+	sb.WriteString(r.Key.String())
+	fmt.Fprintf(&sb, "format:    %s\n", r.Format)
+	for _, c := range r.Columns {
+		fmt.Fprintf(&sb, "  %-20s %-20s %s\n", c.Column, c.Kind, c.Value)
+	}
+	return sb.String()
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"sigs.k8s.io/yaml"
+)
+
+// statMapping maps the keys of one version of the add-index stat blob to
+// phases. Key patterns are globs, so "step-*" sums the values of all keys
+// starting with "step-". A mapping file is a YAML list of mappings, for example:
+//
+//	# mapping.yaml
+//	- version: job-history
+//	  detect: job-total
+//	  total: [job-total]
+//	  phases:
+//	  - name: backfill
+//	    keys: [step-*]
+type statMapping struct {
+	Version string `json:"version"`
+	// Detect is a key pattern that only exists in the stats of this version.
+	Detect string      `json:"detect"`
+	Total  []string    `json:"total"`
+	Phases []statPhase `json:"phases"`
+}
+
+type statPhase struct {
+	Name string   `json:"name"`
+	Keys []string `json:"keys"`
+}
+
+// defaultStatMappings are tried in order. The job-history stat is built by
+// jobStatBlob from the history DDL job and its backfill subtasks, the
+// operator-based stat was introduced with the ingest operators.
+var defaultStatMappings = []statMapping{
+	{
+		Version: "job-history",
+		Detect:  "job-total",
+		Total:   []string{"job-total"},
+		Phases: []statPhase{
+			{Name: "queue", Keys: []string{"job-queue"}},
+			{Name: "run", Keys: []string{"job-run"}},
+			{Name: "read-index", Keys: []string{"step-read-index"}},
+			{Name: "merge-sort", Keys: []string{"step-merge-sort"}},
+			{Name: "write-ingest", Keys: []string{"step-write-ingest"}},
+		},
+	},
+	{
+		Version: "v2",
+		Detect:  "op-scan-records-*",
+		Total:   []string{"add-index-job"},
+		Phases: []statPhase{
+			{Name: "scan", Keys: []string{"op-scan-records-*"}},
+			{Name: "send-chunk", Keys: []string{"op-send-chunk-*"}},
+			{Name: "write-local", Keys: []string{"op-write-local-*"}},
+			{Name: "ingest", Keys: []string{"op-result-collect-flush"}},
+		},
+	},
+	{
+		Version: "v1",
+		Detect:  "scan-records-*",
+		Total:   []string{"add-index-job"},
+		Phases: []statPhase{
+			{Name: "scan", Keys: []string{"scan-records-*"}},
+			{Name: "send-chunk", Keys: []string{"send-chunk-*"}},
+			{Name: "write-local", Keys: []string{"write-local-*"}},
+			{Name: "ingest", Keys: []string{"finish-import-*"}},
+		},
+	},
+}
+
+// backfillStepKeys are the stat keys of the steps of a distributed backfill task.
+var backfillStepKeys = map[string]string{
+	"1": "step-read-index",
+	"2": "step-merge-sort",
+	"3": "step-write-ingest",
+}
+
+// historyJobPath is the TiDB status port path serving a finished DDL job.
+func historyJobPath(jobID string) string {
+	return fmt.Sprintf("/ddl/history?start_job_id=%s&limit=1", jobID)
+}
+
+// parseHistoryJob parses the response of historyJobPath.
+func parseHistoryJob(data []byte, jobID string) (*model.Job, error) {
+	var jobs []*model.Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("invalid history job response: %w [%s]", err, bytes.TrimSpace(data))
+	}
+	if len(jobs) == 0 || strconv.FormatInt(jobs[0].ID, 10) != jobID {
+		return nil, fmt.Errorf("job %s is not found in the DDL history", jobID)
+	}
+	return jobs[0], nil
+}
+
+// subtaskStepsSQL returns the step and the seconds from its first subtask
+// start to its last subtask update of the distributed backfill task of a job.
+// The task key of a subtask is the ID of its task, which is looked up by the
+// task key of the job, prefixed by the keyspace on next-gen clusters. It only
+// quotes with double quotes, bench runs it in a single-quoted mysql -e.
+func subtaskStepsSQL(jobID string) string {
+	taskKey := "ddl/backfill/" + jobID
+	return fmt.Sprintf("select step, max(state_update_time) - min(start_time) from mysql.tidb_background_subtask_history "+
+		"where task_key in (select cast(id as char) from mysql.tidb_global_task where task_key = \"%[1]s\" or task_key like \"%%/%[1]s\" "+
+		"union select cast(id as char) from mysql.tidb_global_task_history where task_key = \"%[1]s\" or task_key like \"%%/%[1]s\") "+
+		"group by step order by step;", taskKey)
+}
+
+// jobStatBlob builds the stat blob of a finished DDL job in seconds. steps are
+// the rows of subtaskStepsSQL, empty if the job doesn't run as a distributed task.
+func jobStatBlob(job *model.Job, steps [][]string) (string, error) {
+	if job.BinlogInfo == nil || job.BinlogInfo.FinishedTS == 0 {
+		return "", fmt.Errorf("job %d is not finished", job.ID)
+	}
+	start := model.TSConvert2Time(job.StartTS)
+	finish := model.TSConvert2Time(job.BinlogInfo.FinishedTS)
+	m := map[string]float64{"job-total": finish.Sub(start).Seconds()}
+	if job.RealStartTS != 0 {
+		realStart := model.TSConvert2Time(job.RealStartTS)
+		m["job-queue"] = realStart.Sub(start).Seconds()
+		m["job-run"] = finish.Sub(realStart).Seconds()
+	}
+	for _, row := range steps {
+		key, ok := backfillStepKeys[row[0]]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(row[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid duration %q of step %s", row[1], row[0])
+		}
+		m[key] = v
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// decodedStat is the per-phase time of an add-index stat blob, in seconds.
+// A phase without any matching key has nil Seconds.
+type decodedStat struct {
+	Version string      `json:"version"`
+	Total   *float64    `json:"total,omitempty"`
+	Phases  []phaseTime `json:"phases"`
+	// Unmapped are the keys not covered by the total or any phase.
+	Unmapped []string `json:"unmapped,omitempty"`
+}
+
+type phaseTime struct {
+	Name    string   `json:"name"`
+	Seconds *float64 `json:"seconds,omitempty"`
+	Percent *float64 `json:"percent,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+}
+
+func loadStatMappings(path string) []statMapping {
+	if path == "" {
+		return defaultStatMappings
+	}
+	data, err := os.ReadFile(path)
+	mustNil(err)
+	var mappings []statMapping
+	err = yaml.UnmarshalStrict(data, &mappings)
+	mustNil(err)
+	if len(mappings) == 0 {
+		mustNil(fmt.Errorf("no stat mapping found in %s", path))
+	}
+	return mappings
+}
+
+// decodeStat decodes a stat blob with the mapping of version. If version is
+// empty, the first mapping whose detect pattern matches is used.
+func decodeStat(data string, mappings []statMapping, version string) (*decodedStat, error) {
+	var m map[string]interface{}
+	err := json.Unmarshal([]byte(data), &m)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := selectStatMapping(m, mappings, version)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	stat := &decodedStat{Version: mapping.Version}
+	if v, keys := sumStatKeys(m, mapping.Total); len(keys) > 0 {
+		stat.Total = &v
+		for _, k := range keys {
+			used[k] = true
+		}
+	}
+	for _, p := range mapping.Phases {
+		pt := phaseTime{Name: p.Name}
+		if v, keys := sumStatKeys(m, p.Keys); len(keys) > 0 {
+			pt.Seconds = &v
+			pt.Keys = keys
+			for _, k := range keys {
+				used[k] = true
+			}
+			if stat.Total != nil && *stat.Total > 0 {
+				pct := v / *stat.Total * 100
+				pt.Percent = &pct
+			}
+		}
+		stat.Phases = append(stat.Phases, pt)
+	}
+	for _, k := range sortedKeys(m) {
+		if !used[k] {
+			stat.Unmapped = append(stat.Unmapped, k)
+		}
+	}
+	return stat, nil
+}
+
+func selectStatMapping(m map[string]interface{}, mappings []statMapping, version string) (*statMapping, error) {
+	for i := range mappings {
+		if version != "" {
+			if mappings[i].Version == version {
+				return &mappings[i], nil
+			}
+			continue
+		}
+		if mappings[i].Detect == "" {
+			return &mappings[i], nil
+		}
+		if _, keys := sumStatKeys(m, []string{mappings[i].Detect}); len(keys) > 0 {
+			return &mappings[i], nil
+		}
+	}
+	if version != "" {
+		return nil, fmt.Errorf("stat version %q is not found in the mapping", version)
+	}
+	return nil, fmt.Errorf("no stat mapping matches the stat, use --stat-version to choose one")
+}
+
+// sumStatKeys sums the values of the keys matching any of the patterns, and
+// returns the matched keys. A value is either a number or an object with "sum".
+func sumStatKeys(m map[string]interface{}, patterns []string) (float64, []string) {
+	var sum float64
+	var keys []string
+	for _, k := range sortedKeys(m) {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, k); !ok {
+				continue
+			}
+			if v, ok := extractSum(m[k]); ok {
+				sum += v
+				keys = append(keys, k)
+			}
+			break
+		}
+	}
+	return sum, keys
+}
+
+func extractSum(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		sum, ok := x["sum"].(float64)
+		return sum, ok
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// phases returns the seconds of the total and the phases found in the stat.
+func (s *decodedStat) phases() map[string]float64 {
+	m := make(map[string]float64)
+	if s.Total != nil {
+		m["total"] = *s.Total
+	}
+	for _, p := range s.Phases {
+		if p.Seconds != nil {
+			m[p.Name] = *p.Seconds
+		}
+	}
+	return m
+}
+
+func (s *decodedStat) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PHASE\tSECONDS\t%%TOTAL\tKEYS\t\n")
+	for _, p := range s.Phases {
+		pct := "-"
+		if p.Percent != nil {
+			pct = fmt.Sprintf("%.1f%%", *p.Percent)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t\n", p.Name, formatOptionalPtr(p.Seconds), pct, len(p.Keys))
+	}
+	fmt.Fprintf(tw, "total\t%s\t\t\t\n", formatOptionalPtr(s.Total))
+	tw.Flush()
+	fmt.Fprintf(w, "version: %s\n", s.Version)
+	if len(s.Unmapped) > 0 {
+		fmt.Fprintf(w, "unmapped: %v\n", s.Unmapped)
+	}
+}
+
+func formatOptionalPtr(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return formatOptional(*v, true)
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tangenta/dbtool/util"
+)
+
+// labeledStat is a stat blob with the job ID or position it comes from.
+type labeledStat struct {
+	Label string       `json:"label"`
+	Stat  *decodedStat `json:"stat,omitempty"`
+	Error string       `json:"error,omitempty"`
+
+	blob string
+}
+
+// phaseSummary is the spread of a phase across stats that have it.
+type phaseSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+}
+
+// readStatBlobs reads concatenated or line-delimited JSON stat blobs from a file, or stdin if path is "-".
+func readStatBlobs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var blobs []string
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stat blob %d: %w", len(blobs)+1, err)
+		}
+		blobs = append(blobs, string(raw))
+	}
+	return blobs, nil
+}
+
+// fetchJobStats builds the stat blobs of finished DDL jobs from the DDL
+// history on the TiDB status port. The backfill steps are read from the
+// subtask history if db is set.
+func fetchJobStats(statusAddr string, db *sql.DB, jobIDs []string) []labeledStat {
+	stats := make([]labeledStat, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		blob, err := fetchJobStat(statusAddr, db, id)
+		if err != nil {
+			stats = append(stats, labeledStat{Label: id, Error: err.Error()})
+			continue
+		}
+		stats = append(stats, labeledStat{Label: id, blob: blob})
+	}
+	return stats
+}
+
+func fetchJobStat(statusAddr string, db *sql.DB, jobID string) (string, error) {
+	if _, err := strconv.ParseInt(jobID, 10, 64); err != nil {
+		return "", fmt.Errorf("invalid job ID %q", jobID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	body, err := httpGet(ctx, statusURL(statusAddr)+historyJobPath(jobID))
+	if err != nil {
+		return "", err
+	}
+	job, err := parseHistoryJob(body, jobID)
+	if err != nil {
+		return "", err
+	}
+	var steps [][]string
+	if db != nil {
+		rows, err := db.QueryContext(ctx, subtaskStepsSQL(jobID))
+		if err != nil {
+			return "", err
+		}
+		steps = util.ReadAll(rows)
+	}
+	return jobStatBlob(job, steps)
+}
+
+// decodeLabeledStats decodes the fetched or read blobs, a blob that cannot be decoded is reported in Error.
+func (ctx *decodeCtx) decodeLabeledStats(stats []labeledStat) {
+	for i := range stats {
+		s := &stats[i]
+		if s.Error != "" {
+			continue
+		}
+		stat, err := decodeStat(s.blob, ctx.statMappings, ctx.statVersion)
+		if err != nil {
+			s.Error = err.Error()
+			continue
+		}
+		s.Stat, s.Error = stat, ""
+	}
+}
+
+// statPhaseNames returns the phase names in the order they first appear, with the total last.
+func statPhaseNames(stats []labeledStat) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, s := range stats {
+		if s.Stat == nil {
+			continue
+		}
+		for _, p := range s.Stat.Phases {
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				names = append(names, p.Name)
+			}
+		}
+	}
+	return append(names, "total")
+}
+
+func summarizeStats(stats []labeledStat) map[string]phaseSummary {
+	summary := make(map[string]phaseSummary)
+	for _, s := range stats {
+		if s.Stat == nil {
+			continue
+		}
+		for name, v := range s.Stat.phases() {
+			ps, ok := summary[name]
+			if !ok {
+				ps = phaseSummary{Min: math.MaxFloat64, Max: -math.MaxFloat64}
+			}
+			ps.Count++
+			ps.Min = math.Min(ps.Min, v)
+			ps.Max = math.Max(ps.Max, v)
+			ps.Avg += v
+			summary[name] = ps
+		}
+	}
+	for name, ps := range summary {
+		ps.Avg /= float64(ps.Count)
+		summary[name] = ps
+	}
+	return summary
+}
+
+// printStatsSideBySide prints one column per stat and the min/avg/max of every phase.
+func printStatsSideBySide(w io.Writer, stats []labeledStat) {
+	summary := summarizeStats(stats)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "PHASE\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t", s.Label)
+	}
+	fmt.Fprintln(tw, "MIN\tAVG\tMAX\t")
+	for _, name := range statPhaseNames(stats) {
+		fmt.Fprintf(tw, "%s\t", name)
+		for _, s := range stats {
+			if s.Stat == nil {
+				fmt.Fprint(tw, "ERR\t")
+				continue
+			}
+			v, ok := s.Stat.phases()[name]
+			fmt.Fprintf(tw, "%s\t", formatOptional(v, ok))
+		}
+		ps, ok := summary[name]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", formatOptional(ps.Min, ok), formatOptional(ps.Avg, ok), formatOptional(ps.Max, ok))
+	}
+	tw.Flush()
+	for _, s := range stats {
+		if s.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", s.Label, s.Error)
+		}
+	}
+}
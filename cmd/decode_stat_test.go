@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/meta/model"
+)
+
+func TestDecodeStatVersions(t *testing.T) {
+	cases := []struct {
+		name     string
+		blob     string
+		version  string
+		phases   map[string]float64
+		unmapped []string
+		err      string
+	}{
+		{
+			name: "v2",
+			blob: `{"add-index-job":62.5,"op-scan-records-1":{"count":120,"sum":20.5},"op-scan-records-2":{"count":118,"sum":19.5},` +
+				`"op-send-chunk-1":{"count":238,"sum":3.25},"op-write-local-1":{"count":238,"sum":15.75},"op-result-collect-flush":2.5}`,
+			version: "v2",
+			phases:  map[string]float64{"total": 62.5, "scan": 40, "send-chunk": 3.25, "write-local": 15.75, "ingest": 2.5},
+		},
+		{
+			name: "v1",
+			blob: `{"add-index-job":40,"scan-records-1":{"count":80,"sum":12},"send-chunk-1":{"count":80,"sum":2},` +
+				`"write-local-1":{"count":80,"sum":9.5},"finish-import-1":1.5,"finish-import-2":0.5,"finish-import-3":0.25,"alloc-ts":0.1}`,
+			version:  "v1",
+			phases:   map[string]float64{"total": 40, "scan": 12, "send-chunk": 2, "write-local": 9.5, "ingest": 2.25},
+			unmapped: []string{"alloc-ts"},
+		},
+		{
+			name:    "v2 without ingest",
+			blob:    `{"add-index-job":10,"op-scan-records-1":{"count":1,"sum":4}}`,
+			version: "v2",
+			phases:  map[string]float64{"total": 10, "scan": 4},
+		},
+		{
+			name:    "job history",
+			blob:    `{"job-total":30,"job-queue":1,"job-run":29,"step-read-index":20,"step-write-ingest":8}`,
+			version: "job-history",
+			phases:  map[string]float64{"total": 30, "queue": 1, "run": 29, "read-index": 20, "write-ingest": 8},
+		},
+		{
+			name: "unknown",
+			blob: `{"foo":1}`,
+			err:  "no stat mapping matches the stat, use --stat-version to choose one",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stat, err := decodeStat(c.blob, defaultStatMappings, "")
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("got error %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Version != c.version {
+				t.Fatalf("got version %s, want %s", stat.Version, c.version)
+			}
+			if got := stat.phases(); !reflect.DeepEqual(got, c.phases) {
+				t.Fatalf("got phases %v, want %v", got, c.phases)
+			}
+			if !reflect.DeepEqual(stat.Unmapped, c.unmapped) {
+				t.Fatalf("got unmapped %v, want %v", stat.Unmapped, c.unmapped)
+			}
+		})
+	}
+}
+
+func TestDecodeStatWithVersion(t *testing.T) {
+	blob := `{"add-index-job":10,"op-scan-records-1":{"count":1,"sum":4},"scan-records-1":{"count":1,"sum":3}}`
+	cases := []struct {
+		version string
+		scan    float64
+		err     string
+	}{
+		{version: "", scan: 4},
+		{version: "v2", scan: 4},
+		{version: "v1", scan: 3},
+		{version: "v3", err: `stat version "v3" is not found in the mapping`},
+	}
+	for _, c := range cases {
+		stat, err := decodeStat(blob, defaultStatMappings, c.version)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("version %q: got error %v, want %s", c.version, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.phases()["scan"]; got != c.scan {
+			t.Fatalf("version %q: got scan %v, want %v", c.version, got, c.scan)
+		}
+	}
+}
+
+func TestParseHistoryJob(t *testing.T) {
+	data, err := json.Marshal([]*model.Job{{ID: 101, Type: model.ActionAddIndex}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		data  string
+		jobID string
+		err   string
+	}{
+		{data: string(data), jobID: "101"},
+		{data: string(data), jobID: "100", err: "job 100 is not found in the DDL history"},
+		{data: "[]", jobID: "101", err: "job 101 is not found in the DDL history"},
+		{data: "404 page not found", jobID: "101", err: "invalid history job response"},
+	}
+	for _, c := range cases {
+		job, err := parseHistoryJob([]byte(c.data), c.jobID)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("%s of %s: got error %v, want %s", c.jobID, c.data, err, c.err)
+			}
+			continue
+		}
+		if err != nil || job.ID != 101 || job.Type != model.ActionAddIndex {
+			t.Fatalf("%s of %s: got %v, %v", c.jobID, c.data, job, err)
+		}
+	}
+}
+
+func TestJobStatBlob(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	// ts returns the TSO of sec seconds after start, the logical part is 0.
+	ts := func(sec int) uint64 {
+		return uint64(start.Add(time.Duration(sec)*time.Second).UnixMilli()) << 18
+	}
+	cases := []struct {
+		name  string
+		job   *model.Job
+		steps [][]string
+		stat  map[string]float64
+		err   string
+	}{
+		{
+			name:  "distributed",
+			job:   &model.Job{ID: 1, StartTS: ts(0), RealStartTS: ts(2), BinlogInfo: &model.HistoryInfo{FinishedTS: ts(62)}},
+			steps: [][]string{{"1", "40"}, {"2", "5"}, {"3", "12"}, {"4", "1"}},
+			stat: map[string]float64{"job-total": 62, "job-queue": 2, "job-run": 60,
+				"step-read-index": 40, "step-merge-sort": 5, "step-write-ingest": 12},
+		},
+		{
+			name: "local",
+			job:  &model.Job{ID: 2, StartTS: ts(0), RealStartTS: ts(1), BinlogInfo: &model.HistoryInfo{FinishedTS: ts(10)}},
+			stat: map[string]float64{"job-total": 10, "job-queue": 1, "job-run": 9},
+		},
+		{
+			name: "no real start",
+			job:  &model.Job{ID: 3, StartTS: ts(0), BinlogInfo: &model.HistoryInfo{FinishedTS: ts(5)}},
+			stat: map[string]float64{"job-total": 5},
+		},
+		{
+			name: "running",
+			job:  &model.Job{ID: 4, StartTS: ts(0), BinlogInfo: &model.HistoryInfo{}},
+			err:  "job 4 is not finished",
+		},
+		{
+			name:  "bad step",
+			job:   &model.Job{ID: 5, StartTS: ts(0), BinlogInfo: &model.HistoryInfo{FinishedTS: ts(5)}},
+			steps: [][]string{{"1", "NULL"}},
+			err:   `invalid duration "NULL" of step 1`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			blob, err := jobStatBlob(c.job, c.steps)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("got error %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]float64
+			if err := json.Unmarshal([]byte(blob), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.stat) {
+				t.Fatalf("got %v, want %v", got, c.stat)
+			}
+			if _, err := decodeStat(blob, defaultStatMappings, ""); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
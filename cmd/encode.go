@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/parser/charset"
+	"github.com/pingcap/tidb/pkg/tablecodec"
+	"github.com/pingcap/tidb/pkg/types"
+	"github.com/pingcap/tidb/pkg/util/codec"
+	"github.com/spf13/cobra"
+)
+
+type encodeCtx struct {
+	tableID   int64
+	indexID   int64
+	handle    string
+	values    []string
+	keyRange  bool
+	tempIndex bool
+	format    string
+
+	dsn              string
+	schemaPath       string
+	statusAddr       string
+	collationEnabled bool
+}
+
+// keyFormats are the key representations printed by "encode key".
+var keyFormats = []string{"hex", "escaped", "pd", "tikv-ctl"}
+
+func init() {
+	ctx := &encodeCtx{}
+	var encodeCmd = &cobra.Command{
+		Use:   "encode",
+		Short: "encode TiDB keys, the inverse of decode",
+		Run:   runEncodeCmd(ctx),
+	}
+	rootCmd.AddCommand(encodeCmd)
+	encodeCmd.Flags().Int64Var(&ctx.tableID, "table-id", 0, "the physical table ID, or partition ID")
+	encodeCmd.Flags().Int64Var(&ctx.indexID, "index-id", 0, "the index ID, 0 means a record key")
+	encodeCmd.Flags().StringVar(&ctx.handle, "handle", "", "the int handle of a record key, or appended to a non-unique index key")
+	encodeCmd.Flags().StringSliceVar(&ctx.values, "values", nil, "the index values, or the common handle of a record key, e.g. 'abc',17,1.5,NULL")
+	encodeCmd.Flags().BoolVar(&ctx.keyRange, "range", false, "print the start and end keys of the records or the index instead")
+	encodeCmd.Flags().BoolVar(&ctx.tempIndex, "temp-index", false, "encode the temporary index used by ingest")
+	encodeCmd.Flags().StringVar(&ctx.format, "format", "", "only print the key in the format, supports "+strings.Join(keyFormats, ", "))
+	encodeCmd.Flags().StringVar(&ctx.dsn, "dsn", "", "look up the column types in a live cluster, e.g. root@tcp(127.0.0.1:4000)/")
+	encodeCmd.Flags().StringVar(&ctx.schemaPath, "schema", "", "look up the column types in a schema dump saved by 'decode schema'")
+	encodeCmd.Flags().StringVar(&ctx.statusAddr, "status-addr", "127.0.0.1:10080", "the TiDB status address to fetch the table schemas of --dsn")
+	encodeCmd.Flags().BoolVar(&ctx.collationEnabled, "new-collation", true, "whether the new collation feature is enabled, i.e. whether string values with the schema are encoded as collation sort keys")
+}
+
+func runEncodeCmd(ctx *encodeCtx) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		cmd.SetUsageFunc(func(c *cobra.Command) error {
+			fmt.Println("Usage: \n  encode key --table-id 42 [--index-id 3] [--values 'abc',17 [--dsn|--schema]] [--handle 1] [--range] [flags]")
+			return nil
+		})
+		if len(args) != 1 || args[0] != "key" || ctx.tableID == 0 {
+			cmd.Usage()
+			return
+		}
+		if ctx.keyRange {
+			start, end := ctx.encodeKeyRange()
+			ctx.printKey("start", start)
+			ctx.printKey("end", end)
+			return
+		}
+		key, err := ctx.encodeKey(loadKeySchema(ctx.schemaPath, ctx.dsn, ctx.statusAddr))
+		mustNil(err)
+		ctx.printKey("key", key)
+	}
+}
+
+func (ctx *encodeCtx) physicalIndexID() int64 {
+	if ctx.tempIndex {
+		return tablecodec.TempIndexPrefix | ctx.indexID
+	}
+	return ctx.indexID
+}
+
+// encodeKey encodes a record key or an index key. With the schema, the values
+// are converted to the column types, so strings are encoded as the sort keys
+// of the column collations, the way TiDB stores them. Without it, strings are
+// encoded as binary.
+func (ctx *encodeCtx) encodeKey(schema *keySchema) (kv.Key, error) {
+	datums, err := parseKeyValues(ctx.values)
+	if err != nil {
+		return nil, err
+	}
+	var tblInfo *model.TableInfo
+	if schema != nil {
+		t := schema.table(ctx.tableID)
+		if t == nil {
+			return nil, fmt.Errorf("table %d is not found in the schema", ctx.tableID)
+		}
+		tblInfo = t.Table
+	} else {
+		for _, d := range datums {
+			if d.Kind() == types.KindString {
+				fmt.Fprintln(os.Stderr, "Warning: string values are encoded as binary, the key differs for a column with a non-binary collation, use --dsn or --schema to encode with the column types")
+				break
+			}
+		}
+	}
+	if ctx.indexID != 0 {
+		var h kv.Handle
+		if ctx.handle != "" {
+			id, err := strconv.ParseInt(ctx.handle, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid handle %q: %w", ctx.handle, err)
+			}
+			h = kv.IntHandle(id)
+		}
+		if tblInfo != nil {
+			return ctx.genIndexKey(tblInfo, datums, h)
+		}
+		if h != nil {
+			datums = append(datums, types.NewIntDatum(h.IntValue()))
+		}
+		encoded, err := codec.EncodeKey(time.UTC, nil, datums...)
+		if err != nil {
+			return nil, err
+		}
+		return tablecodec.EncodeIndexSeekKey(ctx.tableID, ctx.physicalIndexID(), encoded), nil
+	}
+	switch {
+	case ctx.handle != "" && len(datums) > 0:
+		return nil, fmt.Errorf("--handle and --values cannot be both set for a record key")
+	case ctx.handle != "":
+		h, err := strconv.ParseInt(ctx.handle, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid handle %q: %w", ctx.handle, err)
+		}
+		return tablecodec.EncodeRowKeyWithHandle(ctx.tableID, kv.IntHandle(h)), nil
+	case len(datums) > 0:
+		if tblInfo != nil {
+			if !tblInfo.IsCommonHandle {
+				return nil, fmt.Errorf("table %s has no common handle, use --handle instead", tblInfo.Name.O)
+			}
+			pk := tblInfo.GetPrimaryKey()
+			if datums, err = ctx.convertIndexValues(tblInfo, pk, datums); err != nil {
+				return nil, err
+			}
+			tablecodec.TruncateIndexValues(tblInfo, pk, datums)
+		}
+		encoded, err := codec.EncodeKey(time.UTC, nil, datums...)
+		if err != nil {
+			return nil, err
+		}
+		h, err := kv.NewCommonHandle(encoded)
+		if err != nil {
+			return nil, err
+		}
+		return tablecodec.EncodeRowKeyWithHandle(ctx.tableID, h), nil
+	}
+	return tablecodec.GenTableRecordPrefix(ctx.tableID), nil
+}
+
+// genIndexKey encodes an index key the way TiDB writes it: prefix indexes
+// are truncated, and the handle is only appended to non-distinct keys.
+func (ctx *encodeCtx) genIndexKey(tblInfo *model.TableInfo, datums []types.Datum, h kv.Handle) (kv.Key, error) {
+	idxInfo := tblInfo.FindIndexByID(ctx.indexID)
+	if idxInfo == nil {
+		return nil, fmt.Errorf("index %d is not found in table %s", ctx.indexID, tblInfo.Name.O)
+	}
+	datums, err := ctx.convertIndexValues(tblInfo, idxInfo, datums)
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := tablecodec.GenIndexKey(time.UTC, tblInfo, idxInfo, ctx.tableID, datums, h, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.tempIndex {
+		tablecodec.IndexKey2TempIndexKey(key)
+	}
+	return key, nil
+}
+
+// convertIndexValues converts the leading values of an index to the column
+// types. Without the new collation, strings keep the binary collation, all
+// collations compare the bytes then.
+func (ctx *encodeCtx) convertIndexValues(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, datums []types.Datum) ([]types.Datum, error) {
+	if len(datums) > len(idxInfo.Columns) {
+		return nil, fmt.Errorf("index %s has %d column(s), but %d values are given", idxInfo.Name.O, len(idxInfo.Columns), len(datums))
+	}
+	ret := make([]types.Datum, 0, len(datums))
+	for i, d := range datums {
+		col := tblInfo.Columns[idxInfo.Columns[i].Offset]
+		converted, err := d.ConvertTo(types.DefaultStmtNoWarningContext, &col.FieldType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for column %s: %w", col.Name.O, err)
+		}
+		if !ctx.collationEnabled && converted.Kind() == types.KindString {
+			converted.SetCollation(charset.CollationBin)
+		}
+		ret = append(ret, converted)
+	}
+	return ret, nil
+}
+
+// encodeKeyRange returns the range of all records of the table, or all keys of the index.
+func (ctx *encodeCtx) encodeKeyRange() (kv.Key, kv.Key) {
+	prefix := tablecodec.GenTableRecordPrefix(ctx.tableID)
+	if ctx.indexID != 0 {
+		prefix = tablecodec.EncodeTableIndexPrefix(ctx.tableID, ctx.physicalIndexID())
+	}
+	return prefix, prefix.PrefixNext()
+}
+
+// parseKeyValues converts literals to datums: quoted strings, integers, decimals and NULL.
+// An unquoted literal that is not a number is treated as a string.
+func parseKeyValues(values []string) ([]types.Datum, error) {
+	datums := make([]types.Datum, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		switch {
+		case len(v) >= 2 && (v[0] == '\'' || v[0] == '"') && v[len(v)-1] == v[0]:
+			datums = append(datums, types.NewStringDatum(v[1:len(v)-1]))
+		case strings.EqualFold(v, "NULL"):
+			datums = append(datums, types.NewDatum(nil))
+		default:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				datums = append(datums, types.NewIntDatum(i))
+				continue
+			}
+			if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+				datums = append(datums, types.NewUintDatum(u))
+				continue
+			}
+			dec := new(types.MyDecimal)
+			if err := dec.FromString([]byte(v)); err == nil {
+				datums = append(datums, types.NewDecimalDatum(dec))
+				continue
+			}
+			datums = append(datums, types.NewStringDatum(v))
+		}
+	}
+	return datums, nil
+}
+
+func (ctx *encodeCtx) printKey(label string, key kv.Key) {
+	formatted := map[string]string{
+		// TiDB's /mvcc/hex/{key} takes the raw key.
+		"hex":     strings.ToUpper(hex.EncodeToString(key)),
+		"escaped": escapeKey(key),
+		// Region boundaries in PD are memcomparable-encoded.
+		"pd": strings.ToUpper(hex.EncodeToString(codec.EncodeBytes(nil, key))),
+		// tikv-ctl takes the data key: 'z' + memcomparable-encoded key.
+		"tikv-ctl": escapeKey(append([]byte("z"), codec.EncodeBytes(nil, key)...)),
+	}
+	if ctx.format != "" {
+		v, ok := formatted[ctx.format]
+		if !ok {
+			mustNil(fmt.Errorf("unsupported format %q, supports %s", ctx.format, strings.Join(keyFormats, ", ")))
+		}
+		fmt.Println(v)
+		return
+	}
+	fmt.Printf("%s: %s\n", label, decodeTiDBKey(key).Short())
+	for _, f := range keyFormats {
+		fmt.Printf("  %-9s %s\n", f+":", formatted[f])
+	}
+}
+
+// escapeKey escapes a key the way TiKV prints keys: printable ASCII is kept,
+// other bytes are written as 3-digit octal escapes.
+func escapeKey(key []byte) string {
+	var sb strings.Builder
+	for _, b := range key {
+		switch {
+		case b == '\\' || b == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b >= 0x20 && b < 0x7f:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "\\%03o", b)
+		}
+	}
+	return sb.String()
+}
@@ -4,32 +4,97 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-// goroutineCmd represents the goroutineCmd command
-var goroutineCmd = &cobra.Command{
-	Use:   "goroutine",
-	Short: "display goroutines of a TiDB process",
-	Run:   runGoroutineCmd,
+type goroutineCtx struct {
+	raw    bool
+	filter string
+	state  string
+	sortBy string
+	top    int
+
+	interval time.Duration
+	count    int
+	outDir   string
 }
 
 func init() {
+	ctx := &goroutineCtx{}
+	// goroutineCmd represents the goroutineCmd command
+	var goroutineCmd = &cobra.Command{
+		Use:   "goroutine",
+		Short: "display goroutines of a TiDB process",
+		Run:   runGoroutineCmd(ctx),
+	}
 	rootCmd.AddCommand(goroutineCmd)
+	goroutineCmd.Flags().BoolVar(&ctx.raw, "raw", false, "print the raw goroutine dump of a live process")
+	goroutineCmd.Flags().StringVar(&ctx.filter, "filter", "", "only show goroutines with a frame matching the regex, e.g. ddl|ingest|lightning")
+	goroutineCmd.Flags().StringVar(&ctx.state, "state", "", "only show goroutines in the state matching the regex, e.g. semacquire")
+	goroutineCmd.Flags().StringVar(&ctx.sortBy, "sort", "wait", "the order of stack groups, supports wait, count")
+	goroutineCmd.Flags().IntVar(&ctx.top, "top", 20, "the number of stack groups to show, 0 means all")
+	goroutineCmd.Flags().DurationVar(&ctx.interval, "interval", 30*time.Second, "the sampling interval of watch")
+	goroutineCmd.Flags().IntVar(&ctx.count, "count", 0, "the number of samples taken by watch, 0 means until interrupted")
+	goroutineCmd.Flags().StringVar(&ctx.outDir, "out", "./goroutines", "the directory to save dumps taken by watch")
 }
 
-func runGoroutineCmd(cmd *cobra.Command, args []string) {
-	cmd.SetUsageFunc(func(c *cobra.Command) error {
-		fmt.Println("Usage: \n  goroutine http://127.0.0.1:10080")
-		return nil
-	})
-	if len(args) == 0 {
-		cmd.Usage()
-		return
+func runGoroutineCmd(ctx *goroutineCtx) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		cmd.SetUsageFunc(func(c *cobra.Command) error {
+			fmt.Println("Usage: \n  goroutine http://127.0.0.1:10080 [flags]\n  goroutine <dump file|tarball|-> [flags]\n  goroutine watch http://127.0.0.1:10080 [flags]\n  goroutine diff <a.txt|a.tar.gz> <b.txt|b.tar.gz> [flags]")
+			return nil
+		})
+		if len(args) == 0 {
+			cmd.Usage()
+			return
+		}
+		switch args[0] {
+		case "watch":
+			if len(args) != 2 {
+				cmd.Usage()
+				return
+			}
+			if ctx.interval <= 0 {
+				fmt.Printf("invalid --interval %s, it must be positive\n", ctx.interval)
+				return
+			}
+			ctx.watchGoroutines(args[1])
+			return
+		case "diff":
+			if len(args) != 3 {
+				cmd.Usage()
+				return
+			}
+			ctx.diffGoroutineDumps(os.Stdout, args[1], args[2])
+			return
+		}
+		if !isLiveGoroutineSource(args[0]) {
+			dumps := loadGoroutineDumps(args[0])
+			for _, d := range dumps {
+				if len(dumps) > 1 {
+					fmt.Printf("=== %s ===\n", d.name)
+				}
+				ctx.printSummary(os.Stdout, d.goroutines)
+			}
+			return
+		}
+		body := fetchGoroutineDump(args[0])
+		if ctx.raw {
+			fmt.Println(string(body))
+			return
+		}
+		gs, err := parseGoroutineDump(strings.NewReader(string(body)))
+		mustNil(err)
+		ctx.printSummary(os.Stdout, gs)
 	}
-	addr := args[0]
+}
+
+func fetchGoroutineDump(addr string) []byte {
 	if !strings.HasPrefix(addr, "http://") {
 		addr = "http://" + addr
 	}
@@ -40,7 +105,63 @@ func runGoroutineCmd(cmd *cobra.Command, args []string) {
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	mustNil(err)
-	fmt.Println(string(body))
+	return body
+}
+
+func (ctx *goroutineCtx) printSummary(w io.Writer, gs []*goroutineInfo) {
+	total := len(gs)
+	gs = ctx.filterGoroutines(gs)
+	groups := groupGoroutines(gs)
+	err := sortGoroutineGroups(groups, ctx.sortBy)
+	mustNil(err)
+
+	states := make(map[string]int)
+	for _, g := range gs {
+		states[g.State]++
+	}
+	fmt.Fprintf(w, "%d goroutine(s), %d matched, %d stack group(s)\n", total, len(gs), len(groups))
+	for _, s := range sortedKeys(states) {
+		fmt.Fprintf(w, "  %-24s %d\n", s, states[s])
+	}
+	fmt.Fprintln(w)
+
+	shown := groups
+	if ctx.top > 0 && len(shown) > ctx.top {
+		shown = shown[:ctx.top]
+	}
+	for _, g := range shown {
+		printGoroutineGroup(w, g)
+	}
+	if len(shown) < len(groups) {
+		fmt.Fprintf(w, "... %d more stack group(s), use --top 0 to show all\n", len(groups)-len(shown))
+	}
+}
+
+func (ctx *goroutineCtx) filterGoroutines(gs []*goroutineInfo) []*goroutineInfo {
+	if ctx.filter == "" && ctx.state == "" {
+		return gs
+	}
+	var filterRe, stateRe *regexp.Regexp
+	var err error
+	if ctx.filter != "" {
+		filterRe, err = regexp.Compile(ctx.filter)
+		mustNil(err)
+	}
+	if ctx.state != "" {
+		stateRe, err = regexp.Compile(ctx.state)
+		mustNil(err)
+	}
+	ret := make([]*goroutineInfo, 0, len(gs))
+	for _, g := range gs {
+		if filterRe != nil && !g.matches(filterRe) {
+			continue
+		}
+		if stateRe != nil && !stateRe.MatchString(g.State) {
+			continue
+		}
+		ret = append(ret, g)
+	}
+	return ret
 }
 
 func mustNil(err error) {
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lockWaitStates are goroutine states waiting for a lock or on a nil channel.
+// Idle states like select and chan receive are left out, every worker loop
+// waiting for its next task is in them.
+var lockWaitStates = []string{
+	"semacquire",
+	"sync.Mutex.Lock",
+	"sync.RWMutex.Lock",
+	"sync.RWMutex.RLock",
+	"chan receive (nil chan)",
+	"chan send (nil chan)",
+	"select (no cases)",
+}
+
+// goroutineGroupDiff is the change of a stack group between two dumps.
+type goroutineGroupDiff struct {
+	before *goroutineGroup
+	after  *goroutineGroup
+	// stuck are goroutines of the group that exist in both dumps with the same stack.
+	stuck []*goroutineInfo
+	// waitGrew are the stuck goroutines whose wait minutes grew between the dumps.
+	waitGrew []*goroutineInfo
+}
+
+func (d *goroutineGroupDiff) countBefore() int {
+	if d.before == nil {
+		return 0
+	}
+	return len(d.before.Goroutines)
+}
+
+func (d *goroutineGroupDiff) countAfter() int {
+	if d.after == nil {
+		return 0
+	}
+	return len(d.after.Goroutines)
+}
+
+func (d *goroutineGroupDiff) group() *goroutineGroup {
+	if d.after != nil {
+		return d.after
+	}
+	return d.before
+}
+
+// likelyLeak reports a group that keeps all its goroutines and gains new ones.
+func (d *goroutineGroupDiff) likelyLeak() bool {
+	return d.countBefore() > 0 && d.countAfter() > d.countBefore() && len(d.stuck) == d.countBefore()
+}
+
+// likelyDeadlock reports goroutines that keep waiting for a lock longer in the
+// same frame.
+func (d *goroutineGroupDiff) likelyDeadlock() bool {
+	for _, g := range d.waitGrew {
+		if slices.Contains(lockWaitStates, g.State) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffGoroutines compares the stack groups of two dumps. If matchIDs is set,
+// the goroutines with the same ID and stack in both dumps are stuck.
+func diffGoroutines(before, after []*goroutineInfo, matchIDs bool) []*goroutineGroupDiff {
+	diffs := make(map[string]*goroutineGroupDiff)
+	var order []string
+	for _, g := range groupGoroutines(before) {
+		diffs[g.Key] = &goroutineGroupDiff{before: g}
+		order = append(order, g.Key)
+	}
+	for _, g := range groupGoroutines(after) {
+		d, ok := diffs[g.Key]
+		if !ok {
+			d = &goroutineGroupDiff{}
+			diffs[g.Key] = d
+			order = append(order, g.Key)
+		}
+		d.after = g
+	}
+
+	beforeByID := make(map[int]*goroutineInfo, len(before))
+	for _, g := range before {
+		beforeByID[g.ID] = g
+	}
+	for _, g := range after {
+		prev, ok := beforeByID[g.ID]
+		if matchIDs && ok && prev.stackKey() == g.stackKey() {
+			d := diffs[g.stackKey()]
+			d.stuck = append(d.stuck, g)
+			if g.WaitMins > prev.WaitMins {
+				d.waitGrew = append(d.waitGrew, g)
+			}
+		}
+	}
+
+	ret := make([]*goroutineGroupDiff, 0, len(order))
+	for _, key := range order {
+		ret = append(ret, diffs[key])
+	}
+	return ret
+}
+
+func printGoroutineDiff(w io.Writer, before, after []*goroutineInfo, matchIDs bool) {
+	diffs := diffGoroutines(before, after, matchIDs)
+	fmt.Fprintf(w, "goroutines: %d -> %d\n", len(before), len(after))
+	if !matchIDs {
+		fmt.Fprintln(w, "protobuf dumps have no goroutine IDs, only the stack groups are compared")
+	}
+	fmt.Fprintln(w)
+
+	var grew, appeared, vanished, stuck []*goroutineGroupDiff
+	for _, d := range diffs {
+		switch {
+		case d.countBefore() == 0:
+			appeared = append(appeared, d)
+		case d.countAfter() == 0:
+			vanished = append(vanished, d)
+		case d.countAfter() > d.countBefore():
+			grew = append(grew, d)
+		}
+		if len(d.stuck) > 0 {
+			stuck = append(stuck, d)
+		}
+	}
+	byDelta := func(ds []*goroutineGroupDiff) {
+		sort.SliceStable(ds, func(i, j int) bool {
+			return ds[i].countAfter()-ds[i].countBefore() > ds[j].countAfter()-ds[j].countBefore()
+		})
+	}
+	byDelta(grew)
+	byDelta(appeared)
+	sort.SliceStable(vanished, func(i, j int) bool { return vanished[i].countBefore() > vanished[j].countBefore() })
+	sort.SliceStable(stuck, func(i, j int) bool { return len(stuck[i].stuck) > len(stuck[j].stuck) })
+
+	printSection := func(title string, ds []*goroutineGroupDiff, flag func(*goroutineGroupDiff) string) {
+		if len(ds) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "=== %s: %d stack group(s) ===\n", title, len(ds))
+		for _, d := range ds {
+			fmt.Fprintf(w, "%d -> %d%s\n", d.countBefore(), d.countAfter(), flag(d))
+			printGoroutineGroup(w, d.group())
+		}
+	}
+	noFlag := func(*goroutineGroupDiff) string { return "" }
+	printSection("GREW", grew, func(d *goroutineGroupDiff) string {
+		if d.likelyLeak() {
+			return "  LIKELY LEAK"
+		}
+		return ""
+	})
+	printSection("APPEARED", appeared, noFlag)
+	printSection("VANISHED", vanished, noFlag)
+	printSection("STUCK IN THE SAME FRAME", stuck, func(d *goroutineGroupDiff) string {
+		flag := fmt.Sprintf(", %d stuck", len(d.stuck))
+		if d.likelyDeadlock() {
+			flag += "  LIKELY DEADLOCK"
+		}
+		return flag
+	})
+}
+
+// diffGoroutineDumps compares the dumps of two files. The dumps in tarballs
+// are paired by name, a single dump in each file is compared directly.
+func (ctx *goroutineCtx) diffGoroutineDumps(w io.Writer, beforePath, afterPath string) {
+	before, after := loadGoroutineDumps(beforePath), loadGoroutineDumps(afterPath)
+	diff := func(b, a goroutineDump) {
+		matchIDs := !b.syntheticIDs && !a.syntheticIDs
+		printGoroutineDiff(w, ctx.filterGoroutines(b.goroutines), ctx.filterGoroutines(a.goroutines), matchIDs)
+	}
+	if len(before) == 1 && len(after) == 1 {
+		diff(before[0], after[0])
+		return
+	}
+	afterByName := make(map[string]goroutineDump, len(after))
+	for _, d := range after {
+		afterByName[d.name] = d
+	}
+	for _, b := range before {
+		a, ok := afterByName[b.name]
+		if !ok {
+			fmt.Fprintf(w, "=== %s: only in %s ===\n\n", b.name, beforePath)
+			continue
+		}
+		delete(afterByName, b.name)
+		fmt.Fprintf(w, "=== %s ===\n", b.name)
+		diff(b, a)
+	}
+	for _, a := range after {
+		if _, ok := afterByName[a.name]; ok {
+			fmt.Fprintf(w, "=== %s: only in %s ===\n\n", a.name, afterPath)
+		}
+	}
+}
+
+// watchGoroutines samples the goroutine dump periodically, saves every sample
+// and prints the difference from the previous one until interrupted.
+func (ctx *goroutineCtx) watchGoroutines(addr string) {
+	err := os.MkdirAll(ctx.outDir, 0755)
+	mustNil(err)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	var prev []*goroutineInfo
+	ticker := time.NewTicker(ctx.interval)
+	defer ticker.Stop()
+	for i := 0; ctx.count == 0 || i < ctx.count; i++ {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-interrupt:
+				return
+			}
+		}
+		body := fetchGoroutineDump(addr)
+		path := filepath.Join(ctx.outDir, fmt.Sprintf("goroutine-%s.txt", time.Now().Format("20060102-150405")))
+		err := os.WriteFile(path, body, 0644)
+		mustNil(err)
+		gs, err := parseGoroutineDump(strings.NewReader(string(body)))
+		mustNil(err)
+		gs = ctx.filterGoroutines(gs)
+		log.Printf("Saved %d goroutine(s) to %s\n", len(gs), path)
+		if prev != nil {
+			printGoroutineDiff(os.Stdout, prev, gs, true)
+		}
+		prev = gs
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goroutineInfo is a goroutine parsed from a debug=2 goroutine dump.
+type goroutineInfo struct {
+	ID       int
+	State    string
+	WaitMins int
+	Locked   bool
+	Frames   []stackFrame
+	// CreatedBy is the function that started the goroutine, if any.
+	CreatedBy *stackFrame
+}
+
+type stackFrame struct {
+	Func string
+	File string
+	Line int
+}
+
+func (f stackFrame) String() string {
+	return fmt.Sprintf("%s\n\t%s:%d", f.Func, f.File, f.Line)
+}
+
+// goroutineGroup is a set of goroutines sharing the same stack.
+type goroutineGroup struct {
+	Key        string
+	Goroutines []*goroutineInfo
+}
+
+func (g *goroutineGroup) maxWait() int {
+	ret := 0
+	for _, gr := range g.Goroutines {
+		ret = max(ret, gr.WaitMins)
+	}
+	return ret
+}
+
+func (g *goroutineGroup) states() map[string]int {
+	ret := make(map[string]int)
+	for _, gr := range g.Goroutines {
+		ret[gr.State]++
+	}
+	return ret
+}
+
+var goroutineHeaderRe = regexp.MustCompile(`^goroutine (\d+)(?: gp=\S+ m=\S+(?: mp=\S+)?)? \[(.*)\]:$`)
+
+// parseGoroutineDump parses the text of /debug/pprof/goroutine?debug=2.
+func parseGoroutineDump(r io.Reader) ([]*goroutineInfo, error) {
+	var ret []*goroutineInfo
+	var cur *goroutineInfo
+	var pendingFunc string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := goroutineHeaderRe.FindStringSubmatch(line); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			cur = &goroutineInfo{ID: id}
+			parseGoroutineStatus(cur, m[2])
+			ret = append(ret, cur)
+			pendingFunc = ""
+			continue
+		}
+		if cur == nil || line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			if pendingFunc == "" {
+				continue
+			}
+			file, lineNo := parseFrameLocation(strings.TrimSpace(line))
+			frame := stackFrame{File: file, Line: lineNo}
+			if fn, ok := strings.CutPrefix(pendingFunc, "created by "); ok {
+				frame.Func = trimGoroutineSuffix(fn)
+				cur.CreatedBy = &frame
+			} else {
+				frame.Func = trimCallArgs(pendingFunc)
+				cur.Frames = append(cur.Frames, frame)
+			}
+			pendingFunc = ""
+			continue
+		}
+		pendingFunc = line
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func parseGoroutineStatus(g *goroutineInfo, status string) {
+	for i, part := range strings.Split(status, ", ") {
+		if i == 0 {
+			g.State = part
+			continue
+		}
+		if mins, ok := strings.CutSuffix(part, " minutes"); ok {
+			g.WaitMins, _ = strconv.Atoi(mins)
+		} else if part == "locked to thread" {
+			g.Locked = true
+		}
+	}
+}
+
+// parseFrameLocation parses "/path/file.go:123 +0x1d".
+func parseFrameLocation(loc string) (string, int) {
+	if idx := strings.LastIndex(loc, " +0x"); idx >= 0 {
+		loc = loc[:idx]
+	}
+	idx := strings.LastIndex(loc, ":")
+	if idx < 0 {
+		return loc, 0
+	}
+	lineNo, err := strconv.Atoi(loc[idx+1:])
+	if err != nil {
+		return loc, 0
+	}
+	return loc[:idx], lineNo
+}
+
+// trimCallArgs turns "pkg.(*T).f(0xc000123, 0x1)" into "pkg.(*T).f".
+func trimCallArgs(fn string) string {
+	if strings.HasSuffix(fn, ")") {
+		if idx := strings.LastIndex(fn, "("); idx > 0 {
+			return fn[:idx]
+		}
+	}
+	return fn
+}
+
+// trimGoroutineSuffix turns "pkg.f in goroutine 12" into "pkg.f".
+func trimGoroutineSuffix(fn string) string {
+	if idx := strings.Index(fn, " in goroutine "); idx >= 0 {
+		return fn[:idx]
+	}
+	return fn
+}
+
+func (g *goroutineInfo) stackKey() string {
+	var sb strings.Builder
+	for _, f := range g.Frames {
+		fmt.Fprintf(&sb, "%s %s:%d\n", f.Func, f.File, f.Line)
+	}
+	if g.CreatedBy != nil {
+		fmt.Fprintf(&sb, "created by %s %s:%d\n", g.CreatedBy.Func, g.CreatedBy.File, g.CreatedBy.Line)
+	}
+	return sb.String()
+}
+
+func (g *goroutineInfo) matches(re *regexp.Regexp) bool {
+	for _, f := range g.Frames {
+		if re.MatchString(f.Func) || re.MatchString(f.File) {
+			return true
+		}
+	}
+	return g.CreatedBy != nil && re.MatchString(g.CreatedBy.Func)
+}
+
+// groupGoroutines groups goroutines with identical stacks, ordered by the
+// first appearance in the dump.
+func groupGoroutines(gs []*goroutineInfo) []*goroutineGroup {
+	idx := make(map[string]*goroutineGroup)
+	var ret []*goroutineGroup
+	for _, g := range gs {
+		key := g.stackKey()
+		grp, ok := idx[key]
+		if !ok {
+			grp = &goroutineGroup{Key: key}
+			idx[key] = grp
+			ret = append(ret, grp)
+		}
+		grp.Goroutines = append(grp.Goroutines, g)
+	}
+	return ret
+}
+
+func sortGoroutineGroups(groups []*goroutineGroup, by string) error {
+	switch by {
+	case "count":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return len(groups[i].Goroutines) > len(groups[j].Goroutines)
+		})
+	case "wait":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return groups[i].maxWait() > groups[j].maxWait()
+		})
+	default:
+		return fmt.Errorf("invalid sort key %q, supports count, wait", by)
+	}
+	return nil
+}
+
+func printGoroutineGroup(w io.Writer, g *goroutineGroup) {
+	states := g.states()
+	parts := make([]string, 0, len(states))
+	for _, s := range sortedKeys(states) {
+		parts = append(parts, fmt.Sprintf("%s x%d", s, states[s]))
+	}
+	fmt.Fprintf(w, "%d goroutine(s) [%s], max wait %d minutes\n", len(g.Goroutines), strings.Join(parts, ", "), g.maxWait())
+	sample := g.Goroutines[0]
+	for _, f := range sample.Frames {
+		fmt.Fprintf(w, "    %s  %s:%d\n", f.Func, shortFile(f.File), f.Line)
+	}
+	if sample.CreatedBy != nil {
+		fmt.Fprintf(w, "    created by %s  %s:%d\n", sample.CreatedBy.Func, shortFile(sample.CreatedBy.File), sample.CreatedBy.Line)
+	}
+	fmt.Fprintln(w)
+}
+
+// shortFile keeps the last two path elements of a source file.
+func shortFile(file string) string {
+	parts := strings.Split(file, "/")
+	if len(parts) <= 2 {
+		return file
+	}
+	return strings.Join(parts[len(parts)-2:], "/")
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// goroutineDump is a parsed dump with the name of its source.
+type goroutineDump struct {
+	name       string
+	goroutines []*goroutineInfo
+	// syntheticIDs is set for protobuf dumps, which have no goroutine IDs.
+	syntheticIDs bool
+}
+
+// isLiveGoroutineSource reports whether the argument is a status address
+// rather than a saved dump, i.e. a URL or host:port that is not a file.
+func isLiveGoroutineSource(arg string) bool {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return true
+	}
+	if arg == "-" {
+		return false
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(arg)
+	if err != nil || strings.ContainsAny(host, "/\\") {
+		return false
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+// loadGoroutineDumps reads a dump file, "-" for stdin, or a tarball of dumps.
+func loadGoroutineDumps(arg string) []goroutineDump {
+	var data []byte
+	var err error
+	if arg == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(arg)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("goroutine dump file %s not found", arg)
+		}
+	}
+	mustNil(err)
+	dumps, err := parseGoroutineInput(arg, data)
+	mustNil(err)
+	if len(dumps) == 0 {
+		mustNil(fmt.Errorf("no goroutine dump found in %s", arg))
+	}
+	return dumps
+}
+
+// parseGoroutineInput detects the format of data. It supports debug=2 text,
+// pprof protobuf (optionally gzipped), and tar or tar.gz archives of them.
+func parseGoroutineInput(name string, data []byte) ([]goroutineDump, error) {
+	if isGzip(data) {
+		unzipped, err := gunzip(data)
+		if err != nil {
+			return nil, err
+		}
+		if isTar(unzipped) {
+			return parseGoroutineTar(unzipped)
+		}
+		if !isGoroutineText(unzipped) {
+			return parseGoroutineProto(name, data)
+		}
+		data = unzipped
+	}
+	if isTar(data) {
+		return parseGoroutineTar(data)
+	}
+	if isGoroutineText(data) {
+		gs, err := parseGoroutineDump(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return []goroutineDump{{name: name, goroutines: gs}}, nil
+	}
+	return parseGoroutineProto(name, data)
+}
+
+func parseGoroutineTar(data []byte) ([]goroutineDump, error) {
+	var ret []goroutineDump
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Only look at goroutine dumps, other profiles are protobuf as well.
+		if hdr.Typeflag != tar.TypeReg || !strings.Contains(hdr.Name, "goroutine") {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		dumps, err := parseGoroutineInput(hdr.Name, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		ret = append(ret, dumps...)
+	}
+	return ret, nil
+}
+
+// parseGoroutineProto converts a goroutine profile in pprof format. It has no
+// goroutine IDs or states, so every goroutine gets the state "unknown".
+func parseGoroutineProto(name string, data []byte) ([]goroutineDump, error) {
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized goroutine dump format: %w", err)
+	}
+	var gs []*goroutineInfo
+	id := 0
+	for _, s := range p.Sample {
+		var frames []stackFrame
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				frames = append(frames, stackFrame{Func: line.Function.Name, File: line.Function.Filename, Line: int(line.Line)})
+			}
+		}
+		count := int64(1)
+		if len(s.Value) > 0 {
+			count = s.Value[0]
+		}
+		for i := int64(0); i < count; i++ {
+			id++
+			gs = append(gs, &goroutineInfo{ID: id, State: "unknown", Frames: frames})
+		}
+	}
+	return []goroutineDump{{name: name, goroutines: gs, syntheticIDs: true}}, nil
+}
+
+func isGzip(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func isTar(data []byte) bool {
+	return len(data) > 262 && string(data[257:262]) == "ustar"
+}
+
+func isGoroutineText(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("goroutine "))
+}
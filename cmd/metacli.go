@@ -5,13 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/pingcap/tidb/pkg/config"
 	"github.com/pingcap/tidb/pkg/kv"
 	"github.com/pingcap/tidb/pkg/meta"
 	kvstore "github.com/pingcap/tidb/pkg/store"
 	"github.com/pingcap/tidb/pkg/store/driver"
+	"github.com/pingcap/tidb/pkg/store/mockstore"
 	"github.com/spf13/cobra"
 )
 
@@ -19,12 +21,27 @@ type metaCliCtx struct {
 	configPath string
 	store      string
 	path       string
+	pd         string
+	sslCA      string
+	sslCert    string
+	sslKey     string
+	keyspace   string
 	opType     string
 	key        string
 	value      string
+	limit      int
+	jsonOutput bool
+	yes        bool
+	backupDir  string
+	auditLog   string
+	filePath   string
+	interval   time.Duration
+	jobID      int64
+	// restoreClusterID is the cluster the restored backup was taken from.
+	restoreClusterID uint64
 
-	setter func(*meta.Mutator)
-	getter func(*meta.Mutator) float64
+	// write is the validated --op on --key.
+	write *metaWrite
 }
 
 func init() {
@@ -34,133 +51,217 @@ func init() {
 		Run: runMetaCliCmd(ctx),
 	}
 	rootCmd.AddCommand(metaCliCmd)
-	metaCliCmd.Flags().StringVar(&ctx.configPath, "config", "", "path to the tidb config file")
+	metaCliCmd.Flags().StringVar(&ctx.configPath, "config", "", "path to the tidb config file, optional")
 	metaCliCmd.Flags().StringVar(&ctx.store, "store", "tikv", "the storage type, supports tikv, unistore")
-	metaCliCmd.Flags().StringVar(&ctx.path, "path", "", "the path to the storage")
+	metaCliCmd.Flags().StringVar(&ctx.path, "path", "", "the path to the storage, i.e. the PD addresses for tikv or the directory for unistore")
+	metaCliCmd.Flags().StringVar(&ctx.pd, "pd", "", "the PD addresses, e.g. host:2379, the same as --store tikv --path <pd>")
+	metaCliCmd.Flags().StringVar(&ctx.sslCA, "ssl-ca", "", "the CA file to connect to a cluster with TLS")
+	metaCliCmd.Flags().StringVar(&ctx.sslCert, "ssl-cert", "", "the certificate file to connect to a cluster with TLS")
+	metaCliCmd.Flags().StringVar(&ctx.sslKey, "ssl-key", "", "the private key file to connect to a cluster with TLS")
+	metaCliCmd.Flags().StringVar(&ctx.keyspace, "keyspace", "", "the keyspace name")
 	metaCliCmd.Flags().StringVar(&ctx.opType, "op", "get", "the operation type, supports get, put, delete")
 	metaCliCmd.Flags().StringVar(&ctx.key, "key", "", "the key to operate")
 	metaCliCmd.Flags().StringVar(&ctx.value, "value", "", "the value to put")
+	metaCliCmd.Flags().IntVar(&ctx.limit, "limit", 10, "the number of DDL jobs to show in ddl-history, 0 means all")
+	metaCliCmd.Flags().BoolVar(&ctx.jsonOutput, "json", false, "print tables and DDL jobs in JSON")
+	metaCliCmd.Flags().BoolVarP(&ctx.yes, "yes", "y", false, "apply put, delete and restore, otherwise only print what would change")
+	metaCliCmd.Flags().StringVar(&ctx.backupDir, "backup-dir", defaultMetaBackupDir, "the directory to save the previous values before writing")
+	metaCliCmd.Flags().StringVar(&ctx.auditLog, "audit-log", defaultMetaAuditLog, "the file to append every change to")
+	metaCliCmd.Flags().DurationVar(&ctx.interval, "interval", 5*time.Second, "the polling interval of watch")
+	metaCliCmd.Flags().Int64Var(&ctx.jobID, "job-id", 0, "the running DDL job to show the progress of in watch")
+	metaCliCmd.Flags().StringVarP(&ctx.filePath, "file", "f", "", "the YAML file of keys to apply, or to dump to instead of stdout")
 }
 
 func runMetaCliCmd(ctx *metaCliCtx) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
-		if ctx.configPath == "" {
+		cmd.SetUsageFunc(func(c *cobra.Command) error {
+			fmt.Println("Usage: \n  metacli --pd <host:2379>|--store unistore --path <dir> ...\n  metacli --op get|put|delete --key <key> [--value <value>] [--yes] [flags]\n  metacli restore <backup file> [--yes] [flags]\n  metacli list-keys [--json] [flags]\n  metacli apply -f <file> [--yes] [flags]\n  metacli dump [-f <file>] [flags]\n  metacli watch [--job-id <id>] [--interval 5s] [flags]\n  metacli dbs|tables <db>|table <db> <table|id>|schema-version|global-id|bootstrap-version [flags]\n  metacli ddl-jobs|ddl-history [--limit 10] [flags]")
+			return nil
+		})
+		if ctx.configPath == "" && ctx.pd == "" && ctx.path == "" {
 			cmd.Usage()
 			os.Exit(1)
 		}
+		if len(args) == 2 && args[0] == "restore" {
+			restoreMetaKV(ctx, args[1])
+			return
+		}
+		if len(args) == 1 {
+			switch args[0] {
+			case "list-keys":
+				listMetaKeys(ctx)
+				return
+			case "apply":
+				applyMetaFile(ctx)
+				return
+			case "dump":
+				dumpMetaKeys(ctx)
+				return
+			case "watch":
+				watchMeta(ctx)
+				return
+			}
+		}
+		if len(args) > 0 {
+			browseMeta(ctx, args)
+			return
+		}
 		runMetaKVChange(ctx)
 	}
 }
 
+// runMetaKVChange reads or writes a meta key.
 func runMetaKVChange(mCtx *metaCliCtx) {
 	if !metaCliConfigIsValid(mCtx) {
 		return
 	}
-	supressLogOutput()
-	config.InitializeConfig(mCtx.configPath, false, false, func(c *config.Config, fs *flag.FlagSet) {}, nil)
-	config.UpdateGlobal(func(conf *config.Config) {
-		conf.Store = config.StoreType(mCtx.store)
-		conf.Path = mCtx.path
-	})
-	cfg := config.GetGlobalConfig()
-	err := kvstore.Register(config.StoreTypeTiKV, &driver.TiKVDriver{})
-	mustNil(err)
-	store := kvstore.MustInitStorage(cfg.KeyspaceName)
+	store := openMetaStore(mCtx)
 	defer store.Close()
 
-	err = kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
+	if mCtx.opType == "get" {
+		fmt.Printf("key %s has value %s\n", mCtx.key, readMetaKeys(store, []*metaWrite{mCtx.write})[0])
+		return
+	}
+	applyMetaWrites(mCtx, store, []*metaWrite{mCtx.write})
+}
+
+// applyMetaWrites writes the keys in one transaction, skipping the keys that
+// already have the value or are deleted while not set. It is only a dry run unless --yes is set, and the
+// previous values are saved to a backup file and the audit log before they
+// are applied.
+func applyMetaWrites(mCtx *metaCliCtx, store kv.Storage, writes []*metaWrite) {
+	olds := readMetaKeys(store, writes)
+	var changes []*metaChange
+	var pending []*metaWrite
+	for i, w := range writes {
+		change := newMetaChange(store, w, olds[i])
+		if change.Old == change.New || (w.opType == "delete" && change.Old == metaNullValue) {
+			fmt.Printf("key %s is unchanged: %s\n", change.Key, change.Old)
+			continue
+		}
+		changes = append(changes, change)
+		pending = append(pending, w)
+	}
+	if len(changes) == 0 {
+		return
+	}
+	if clusterID := changes[0].ClusterID; mCtx.restoreClusterID != 0 && mCtx.restoreClusterID != clusterID {
+		mustNil(fmt.Errorf("the backup is taken from cluster %d, but the current cluster is %d", mCtx.restoreClusterID, clusterID))
+	}
+	if !mCtx.yes {
+		for _, change := range changes {
+			fmt.Printf("[dry run] key %s: %s -> %s\n", change.Key, change.Old, change.New)
+		}
+		fmt.Println("Run again with --yes to apply the change.")
+		return
+	}
+	if mCtx.backupDir != "" {
+		backup := saveMetaBackup(mCtx.backupDir, changes)
+		for _, change := range changes {
+			change.Backup = backup
+		}
+		fmt.Printf("Saved the previous value to %s\n", backup)
+	}
+
+	err := kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
 		m := meta.NewMutator(txn)
-		switch mCtx.opType {
-		case "get":
-			v := mCtx.getter(m)
-			fmt.Printf("key %s has value %f\n", mCtx.key, v)
-			return nil
-		case "put", "delete":
-			mCtx.setter(m)
+		for i, w := range pending {
+			// Refuse to overwrite a value changed after it was backed up.
+			if cur, _ := w.key.read(m); cur != changes[i].Old {
+				return fmt.Errorf("key %s changed to %s during the write, please retry", w.key.Name, cur)
+			}
+			if err := w.key.write(m, w.opType, w.value); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 	mustNil(err)
-	err = kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
+	if mCtx.auditLog != "" {
+		for _, change := range changes {
+			appendMetaAudit(mCtx.auditLog, change)
+		}
+	}
+	for i, v := range readMetaKeys(store, pending) {
+		fmt.Printf("key %s has value %s\n", pending[i].key.Name, v)
+	}
+}
+
+// readMetaKeys returns the formatted values of the keys in one snapshot, metaNullValue if a key is not set.
+func readMetaKeys(store kv.Storage, writes []*metaWrite) []string {
+	values := make([]string, len(writes))
+	err := kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
 		m := meta.NewMutator(txn)
-		v := mCtx.getter(m)
-		fmt.Printf("key %s has value %f\n", mCtx.key, v)
+		for i, w := range writes {
+			values[i], _ = w.key.read(m)
+		}
 		return nil
 	})
 	mustNil(err)
+	return values
 }
 
+// openMetaStore connects to the storage of the TiDB cluster. The config file
+// is optional, the flags override it.
+func openMetaStore(mCtx *metaCliCtx) kv.Storage {
+	supressLogOutput()
+	config.InitializeConfig(mCtx.configPath, false, false, func(c *config.Config, fs *flag.FlagSet) {
+		c.Store = config.StoreType(mCtx.store)
+		c.Path = mCtx.path
+		if mCtx.pd != "" {
+			c.Store, c.Path = config.StoreTypeTiKV, mCtx.pd
+		}
+		if mCtx.sslCA != "" || mCtx.sslCert != "" || mCtx.sslKey != "" {
+			c.Security.ClusterSSLCA = mCtx.sslCA
+			c.Security.ClusterSSLCert = mCtx.sslCert
+			c.Security.ClusterSSLKey = mCtx.sslKey
+		}
+		if mCtx.keyspace != "" {
+			c.KeyspaceName = mCtx.keyspace
+		}
+	}, nil)
+	cfg := config.GetGlobalConfig()
+	registerStoreDriversOnce.Do(func() {
+		err := kvstore.Register(config.StoreTypeTiKV, &driver.TiKVDriver{})
+		mustNil(err)
+		err = kvstore.Register(config.StoreTypeUniStore, mockstore.EmbedUnistoreDriver{})
+		mustNil(err)
+	})
+	return kvstore.MustInitStorage(cfg.KeyspaceName)
+}
+
+var registerStoreDriversOnce sync.Once
+
 func metaCliConfigIsValid(ctx *metaCliCtx) bool {
-	if ctx.configPath == "" {
-		fmt.Println("please provide the path to the tidb config file")
-		return false
-	}
+	return metaStoreConfigIsValid(ctx) && metaKeyIsValid(ctx)
+}
+
+func metaStoreConfigIsValid(ctx *metaCliCtx) bool {
 	storeTp := config.StoreType(ctx.store)
-	if !storeTp.Valid() {
+	if storeTp != config.StoreTypeTiKV && storeTp != config.StoreTypeUniStore {
 		fmt.Printf("invalid store type %s\n", ctx.store)
 		return false
 	}
-	if ctx.path == "" {
-		fmt.Printf("please provide the path to the storage\n")
-		return false
-	}
-	var val float64
-	switch ctx.opType {
-	case "get":
-	case "delete":
-		val = 0
-	case "put":
-		v, err := strconv.ParseFloat(ctx.value, 64)
-		if err != nil || v < 0 {
-			fmt.Printf("invalid value %s for key %s\n", ctx.value, ctx.key)
+	if ctx.pd != "" {
+		if storeTp != config.StoreTypeTiKV || ctx.path != "" {
+			fmt.Println("--pd cannot be used with --path or a store other than tikv")
 			return false
 		}
-		val = float64(v)
-	default:
-		fmt.Printf("invalid operation type %s\n", ctx.opType)
+		return true
+	}
+	if ctx.path == "" {
+		fmt.Printf("please provide the PD addresses with --pd, or the path to the storage with --path\n")
 		return false
 	}
-	switch ctx.key {
-	case "max-batch-split-ranges":
-		ctx.getter = func(m *meta.Mutator) float64 {
-			v, _, err := m.GetIngestMaxBatchSplitRanges()
-			mustNil(err)
-			return float64(v)
-		}
-		ctx.setter = func(m *meta.Mutator) {
-			m.SetIngestMaxBatchSplitRanges(int(val))
-		}
-	case "max-split-ranges-per-sec":
-		ctx.getter = func(m *meta.Mutator) float64 {
-			v, _, err := m.GetIngestMaxSplitRangesPerSec()
-			mustNil(err)
-			return v
-		}
-		ctx.setter = func(m *meta.Mutator) {
-			m.SetIngestMaxSplitRangesPerSec(val)
-		}
+	return true
+}
 
-	case "max-ingest-per-sec":
-		ctx.getter = func(m *meta.Mutator) float64 {
-			v, _, err := m.GetIngestMaxPerSec()
-			mustNil(err)
-			return v
-		}
-		ctx.setter = func(m *meta.Mutator) {
-			m.SetIngestMaxPerSec(val)
-		}
-	case "max-ingest-inflight":
-		ctx.getter = func(m *meta.Mutator) float64 {
-			v, _, err := m.GetIngestMaxInflight()
-			mustNil(err)
-			return float64(v)
-		}
-		ctx.setter = func(m *meta.Mutator) {
-			m.SetIngestMaxInflight(int(val))
-		}
-	default:
-		fmt.Printf("invalid key %s\n", ctx.key)
+func metaKeyIsValid(ctx *metaCliCtx) bool {
+	w, err := parseMetaWrite(ctx.key, ctx.opType, ctx.value)
+	if err != nil {
+		fmt.Println(err)
 		return false
 	}
+	ctx.write = w
 	return true
 }
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta"
+	"sigs.k8s.io/yaml"
+)
+
+// loadMetaApplyFile reads a YAML map of key to value, in the format written by
+// dump. Only writable keys are accepted. A null value deletes the key, or is
+// skipped if the key cannot be deleted. The writes are in the order of the
+// registry.
+func loadMetaApplyFile(path string) ([]*metaWrite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	err = yaml.UnmarshalStrict(data, &values)
+	if err != nil {
+		return nil, err
+	}
+	for name := range values {
+		k := findMetaKey(name)
+		if k == nil {
+			return nil, fmt.Errorf("invalid key %s in %s, run metacli list-keys for the supported keys", name, path)
+		}
+		if k.ReadOnly {
+			return nil, fmt.Errorf("key %s in %s is read-only", name, path)
+		}
+	}
+	var writes []*metaWrite
+	for _, k := range metaKeys {
+		raw, ok := values[k.Name]
+		if !ok {
+			continue
+		}
+		opType, value := "put", string(raw)
+		if value == "null" {
+			if k.clear == nil && k.Default == "" {
+				fmt.Printf("key %s cannot be deleted, skip the null value\n", k.Name)
+				continue
+			}
+			opType = "delete"
+		} else if k.Type != metaKeyJSON && strings.HasPrefix(value, `"`) {
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		w, err := parseMetaWrite(k.Name, opType, value)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, w)
+	}
+	return writes, nil
+}
+
+// applyMetaFile sets all keys in the file in one transaction.
+func applyMetaFile(mCtx *metaCliCtx) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	if mCtx.filePath == "" {
+		fmt.Println("please provide the file to apply with -f")
+		return
+	}
+	writes, err := loadMetaApplyFile(mCtx.filePath)
+	mustNil(err)
+	if len(writes) == 0 {
+		fmt.Printf("no key to apply in %s\n", mCtx.filePath)
+		return
+	}
+	store := openMetaStore(mCtx)
+	defer store.Close()
+	applyMetaWrites(mCtx, store, writes)
+}
+
+// dumpMetaKeys writes the writable keys as a YAML file that apply accepts.
+// The read-only keys are left out, they belong to the cluster they are read
+// from.
+func dumpMetaKeys(mCtx *metaCliCtx) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	store := openMetaStore(mCtx)
+	defer store.Close()
+
+	values := make(map[string]any, len(metaKeys))
+	err := kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
+		m := meta.NewMutator(txn)
+		for _, k := range metaKeys {
+			if k.ReadOnly {
+				continue
+			}
+			v, isNull, err := k.get(m)
+			if err != nil {
+				return err
+			}
+			if isNull {
+				v = nil
+			}
+			values[k.Name] = v
+		}
+		return nil
+	})
+	mustNil(err)
+	data, err := yaml.Marshal(values)
+	mustNil(err)
+	header := fmt.Sprintf("# metacli dump of cluster %d at %s\n", store.GetClusterID(), time.Now().Format(time.RFC3339))
+	data = append([]byte(header), data...)
+	if mCtx.filePath == "" {
+		fmt.Print(string(data))
+		return
+	}
+	err = os.WriteFile(mCtx.filePath, data, 0644)
+	mustNil(err)
+	fmt.Printf("Dumped %d keys to %s\n", len(values), mCtx.filePath)
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta"
+	"github.com/pingcap/tidb/pkg/meta/metadef"
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/tablecodec"
+	"github.com/pingcap/tidb/pkg/types"
+)
+
+// browseMeta prints the meta structure read from a snapshot, it never writes.
+func browseMeta(mCtx *metaCliCtx, args []string) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	store := openMetaStore(mCtx)
+	defer store.Close()
+	ver, err := store.CurrentVersion(kv.GlobalTxnScope)
+	mustNil(err)
+	snapshot := store.GetSnapshot(ver)
+	r := meta.NewReader(snapshot)
+
+	switch args[0] {
+	case "dbs":
+		printMetaDatabases(r)
+	case "tables":
+		if len(args) != 2 {
+			fmt.Println("Usage: metacli tables <db>")
+			return
+		}
+		printMetaTables(r, findMetaDatabase(r, args[1]))
+	case "table":
+		if len(args) != 3 {
+			fmt.Println("Usage: metacli table <db> <table|id>")
+			return
+		}
+		printMetaTableInfo(r, findMetaDatabase(r, args[1]), args[2])
+	case "schema-version":
+		v, err := r.GetSchemaVersion()
+		mustNil(err)
+		fmt.Println(v)
+	case "global-id":
+		v, err := r.GetGlobalID()
+		mustNil(err)
+		fmt.Println(v)
+	case "bootstrap-version":
+		v, err := r.GetBootstrapVersion()
+		mustNil(err)
+		fmt.Println(v)
+	case "ddl-jobs":
+		jobs, err := readDDLTableJobs(snapshot, r, metadef.TiDBDDLJobTableID, 0, false)
+		mustNil(err)
+		mCtx.printDDLJobs(jobs)
+	case "ddl-history":
+		jobs, err := readDDLTableJobs(snapshot, r, metadef.TiDBDDLHistoryTableID, mCtx.limit, true)
+		if err != nil {
+			// Clusters before the DDL tables keep the history in meta.
+			jobs, err = readMetaHistoryJobs(r, mCtx.limit)
+		}
+		mustNil(err)
+		mCtx.printDDLJobs(jobs)
+	default:
+		fmt.Printf("unknown meta object %s\n", args[0])
+	}
+}
+
+func printMetaDatabases(r meta.Reader) {
+	dbs, err := r.ListDatabases()
+	mustNil(err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCHARSET\tCOLLATE\tSTATE\t")
+	for _, db := range dbs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t\n", db.ID, db.Name.O, db.Charset, db.Collate, db.State)
+	}
+	w.Flush()
+}
+
+// findMetaDatabase finds a database by name or ID.
+func findMetaDatabase(r meta.Reader, nameOrID string) *model.DBInfo {
+	dbs, err := r.ListDatabases()
+	mustNil(err)
+	for _, db := range dbs {
+		if strings.EqualFold(db.Name.O, nameOrID) || strconv.FormatInt(db.ID, 10) == nameOrID {
+			return db
+		}
+	}
+	mustNil(fmt.Errorf("database %s is not found", nameOrID))
+	return nil
+}
+
+func printMetaTables(r meta.Reader, db *model.DBInfo) {
+	tables, err := r.ListSimpleTables(db.ID)
+	mustNil(err)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\t")
+	for _, t := range tables {
+		fmt.Fprintf(w, "%d\t%s\t\n", t.ID, t.Name.O)
+	}
+	w.Flush()
+}
+
+// printMetaTableInfo prints the TableInfo JSON as stored in meta, found by name or ID.
+func printMetaTableInfo(r meta.Reader, db *model.DBInfo, nameOrID string) {
+	pairs, err := r.GetMetasByDBID(db.ID)
+	mustNil(err)
+	for _, p := range pairs {
+		if !strings.HasPrefix(string(p.Field), "Table:") {
+			continue
+		}
+		tbl := &model.TableInfo{}
+		err := json.Unmarshal(p.Value, tbl)
+		mustNil(err)
+		if !strings.EqualFold(tbl.Name.O, nameOrID) && strconv.FormatInt(tbl.ID, 10) != nameOrID {
+			continue
+		}
+		var buf bytes.Buffer
+		err = json.Indent(&buf, p.Value, "", "  ")
+		mustNil(err)
+		fmt.Println(buf.String())
+		return
+	}
+	mustNil(fmt.Errorf("table %s is not found in database %s", nameOrID, db.Name.O))
+}
+
+// readDDLTableJobs reads the jobs stored in the job_meta column of the DDL
+// system table, e.g. mysql.tidb_ddl_job, in the order of job ID.
+func readDDLTableJobs(snapshot kv.Snapshot, r meta.Reader, tableID int64, limit int, reverse bool) ([]*model.Job, error) {
+	tblInfo, err := r.GetTable(metadef.SystemDatabaseID, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if tblInfo == nil {
+		return nil, fmt.Errorf("system table %d is not found", tableID)
+	}
+	col := model.FindColumnInfo(tblInfo.Columns, "job_meta")
+	if col == nil {
+		return nil, fmt.Errorf("column job_meta is not found in %s", tblInfo.Name.O)
+	}
+	prefix := tablecodec.GenTableRecordPrefix(tableID)
+	var iter kv.Iterator
+	if reverse {
+		iter, err = snapshot.IterReverse(prefix.PrefixNext(), prefix)
+	} else {
+		iter, err = snapshot.Iter(prefix, prefix.PrefixNext())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var jobs []*model.Job
+	cols := map[int64]*types.FieldType{col.ID: &col.FieldType}
+	for iter.Valid() && iter.Key().HasPrefix(prefix) && (limit <= 0 || len(jobs) < limit) {
+		row, err := tablecodec.DecodeRowToDatumMap(iter.Value(), cols, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		jobMeta := row[col.ID]
+		job := &model.Job{}
+		err = job.Decode(jobMeta.GetBytes())
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+		err = iter.Next()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+func readMetaHistoryJobs(r meta.Reader, limit int) ([]*model.Job, error) {
+	iter, err := r.GetLastHistoryDDLJobsIterator()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		cnt, err := r.GetHistoryDDLCount()
+		if err != nil {
+			return nil, err
+		}
+		limit = int(cnt)
+	}
+	return iter.GetLastJobs(limit, nil)
+}
+
+func (mCtx *metaCliCtx) printDDLJobs(jobs []*model.Job) {
+	if mCtx.jsonOutput {
+		printJSON(jobs)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB_ID\tTYPE\tSCHEMA\tTABLE\tSTATE\tSCHEMA_STATE\tROW_COUNT\tQUERY\t")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t\n", job.ID, job.Type, job.SchemaName, job.TableName,
+			job.State, job.SchemaState, job.GetRowCount(), strings.Join(strings.Fields(job.Query), " "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta"
+)
+
+type metaKeyType string
+
+const (
+	metaKeyInt    metaKeyType = "int"
+	metaKeyFloat  metaKeyType = "float"
+	metaKeyBool   metaKeyType = "bool"
+	metaKeyJSON   metaKeyType = "json"
+	metaKeyString metaKeyType = "string"
+)
+
+// metaKeyDef describes a meta key supported by metacli. Values are parsed to
+// int64, float64, bool, json.RawMessage or string by the type.
+type metaKeyDef struct {
+	Name        string      `json:"name"`
+	Type        metaKeyType `json:"type"`
+	Description string      `json:"description"`
+	// Min and Max are the inclusive range of an int or float key, empty means unbounded.
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+	// Decimals is the number of decimal places TiDB stores of a float key, 0 means all.
+	Decimals int `json:"decimals,omitempty"`
+	// Values are the allowed values of a string key, empty means any.
+	Values []string `json:"values,omitempty"`
+	// Default is the value TiDB treats as the key not being set, it is also what delete
+	// writes for a key that cannot be cleared. Empty means there is no default.
+	Default string `json:"default,omitempty"`
+	// ReadOnly keys are changed by a system variable or a statement that also
+	// notifies the other TiDB nodes, metacli only reads them.
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	get func(m *meta.Mutator) (v any, isNull bool, err error)
+	set func(m *meta.Mutator, v any) error
+	// clear removes the key, nil if the key can only be overwritten.
+	clear func(m *meta.Mutator) error
+}
+
+// metaKeys is the registry of the keys metacli can read, and write unless
+// they are read-only. Adding a key only needs an entry here.
+var metaKeys = []*metaKeyDef{
+	{
+		Name:        "max-batch-split-ranges",
+		Type:        metaKeyInt,
+		Description: "max ranges in a batch to split and scatter during ingest, 0 means the default of 2048",
+		Min:         "0",
+		Max:         strconv.Itoa(math.MaxInt32),
+		Default:     "0",
+		get: func(m *meta.Mutator) (any, bool, error) {
+			v, isNull, err := m.GetIngestMaxBatchSplitRanges()
+			return int64(v), isNull, err
+		},
+		set: func(m *meta.Mutator, v any) error {
+			return m.SetIngestMaxBatchSplitRanges(int(v.(int64)))
+		},
+	},
+	{
+		Name:        "max-split-ranges-per-sec",
+		Type:        metaKeyFloat,
+		Description: "max ranges to split and scatter per second during ingest, 0 means unlimited",
+		Min:         "0",
+		Decimals:    2,
+		Default:     "0",
+		get: func(m *meta.Mutator) (any, bool, error) {
+			return m.GetIngestMaxSplitRangesPerSec()
+		},
+		set: func(m *meta.Mutator, v any) error {
+			return m.SetIngestMaxSplitRangesPerSec(v.(float64))
+		},
+	},
+	{
+		Name:        "max-ingest-per-sec",
+		Type:        metaKeyFloat,
+		Description: "max ingest requests per second, 0 means unlimited",
+		Min:         "0",
+		Decimals:    2,
+		Default:     "0",
+		get: func(m *meta.Mutator) (any, bool, error) {
+			return m.GetIngestMaxPerSec()
+		},
+		set: func(m *meta.Mutator, v any) error {
+			return m.SetIngestMaxPerSec(v.(float64))
+		},
+	},
+	{
+		Name:        "max-ingest-inflight",
+		Type:        metaKeyInt,
+		Description: "max concurrent ingest requests, 0 means unlimited",
+		Min:         "0",
+		Max:         strconv.Itoa(math.MaxInt32),
+		Default:     "0",
+		get: func(m *meta.Mutator) (any, bool, error) {
+			v, isNull, err := m.GetIngestMaxInflight()
+			return int64(v), isNull, err
+		},
+		set: func(m *meta.Mutator, v any) error {
+			return m.SetIngestMaxInflight(int(v.(int64)))
+		},
+	},
+	{
+		Name:        "metadata-lock",
+		Type:        metaKeyBool,
+		Description: "whether the metadata lock is enabled, change it with SET GLOBAL tidb_enable_metadata_lock",
+		Default:     "true",
+		ReadOnly:    true,
+		get: func(m *meta.Mutator) (any, bool, error) {
+			return m.GetMetadataLock()
+		},
+	},
+	{
+		Name:        "schema-cache-size",
+		Type:        metaKeyInt,
+		Description: "the schema cache size in bytes, change it with SET GLOBAL tidb_schema_cache_size",
+		Min:         "0",
+		Max:         strconv.FormatInt(math.MaxInt64, 10),
+		Default:     strconv.Itoa(512 * 1024 * 1024),
+		ReadOnly:    true,
+		get: func(m *meta.Mutator) (any, bool, error) {
+			v, isNull, err := m.GetSchemaCacheSize()
+			return int64(v), isNull, err
+		},
+	},
+	{
+		Name:        "bdr-role",
+		Type:        metaKeyString,
+		Description: "the BDR role of the cluster, change it with ADMIN SET BDR ROLE",
+		Values:      []string{"primary", "secondary"},
+		ReadOnly:    true,
+		get: func(m *meta.Mutator) (any, bool, error) {
+			v, err := m.GetBDRRole()
+			return v, v == "", err
+		},
+	},
+	{
+		Name:        "ru-stats",
+		Type:        metaKeyJSON,
+		Description: "the persisted request unit stats of resource control, written by the owner",
+		ReadOnly:    true,
+		get: func(m *meta.Mutator) (any, bool, error) {
+			stats, err := m.GetRUStats()
+			if err != nil || stats == nil {
+				return nil, true, err
+			}
+			data, err := json.Marshal(stats)
+			return json.RawMessage(data), false, err
+		},
+	},
+}
+
+func findMetaKey(name string) *metaKeyDef {
+	for _, k := range metaKeys {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// parse parses and validates a value of the key.
+func (k *metaKeyDef) parse(s string) (any, error) {
+	v, err := k.parseType(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Values) > 0 && !slices.Contains(k.Values, v.(string)) {
+		return nil, fmt.Errorf("must be one of %s", strings.Join(k.Values, ", "))
+	}
+	if f, ok := v.(float64); ok && k.Decimals > 0 {
+		// TiDB formats the value with the decimal places, the rest would be lost.
+		if rounded, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', k.Decimals, 64), 64); rounded != f {
+			return nil, fmt.Errorf("more than %d decimal places", k.Decimals)
+		}
+	}
+	for _, bound := range []struct {
+		s   string
+		cmp int
+	}{{k.Min, -1}, {k.Max, 1}} {
+		if bound.s == "" {
+			continue
+		}
+		b, err := k.parseType(bound.s)
+		mustNil(err)
+		if compareMetaValues(v, b) == bound.cmp {
+			return nil, fmt.Errorf("out of range %s", k.rangeString())
+		}
+	}
+	return v, nil
+}
+
+func (k *metaKeyDef) parseType(s string) (any, error) {
+	switch k.Type {
+	case metaKeyInt:
+		return strconv.ParseInt(s, 10, 64)
+	case metaKeyFloat:
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = fmt.Errorf("%s is not a finite number", s)
+		}
+		return v, err
+	case metaKeyBool:
+		return strconv.ParseBool(s)
+	case metaKeyJSON:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(s)); err != nil {
+			return nil, err
+		}
+		return json.RawMessage(buf.Bytes()), nil
+	case metaKeyString:
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown type %s of key %s", k.Type, k.Name)
+}
+
+// compareMetaValues compares two parsed values of an int or float key.
+func compareMetaValues(a, b any) int {
+	switch x := a.(type) {
+	case int64:
+		return cmp.Compare(x, b.(int64))
+	case float64:
+		return cmp.Compare(x, b.(float64))
+	}
+	return 0
+}
+
+func (k *metaKeyDef) format(v any, isNull bool) string {
+	if isNull {
+		return metaNullValue
+	}
+	switch x := v.(type) {
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case json.RawMessage:
+		return string(x)
+	}
+	return fmt.Sprint(v)
+}
+
+func (k *metaKeyDef) rangeString() string {
+	if len(k.Values) > 0 {
+		return strings.Join(k.Values, "|")
+	}
+	if k.Min == "" && k.Max == "" {
+		return "-"
+	}
+	lower, upper := "(-inf", "+inf)"
+	if k.Min != "" {
+		lower = "[" + k.Min
+	}
+	if k.Max != "" {
+		upper = k.Max + "]"
+	}
+	return lower + ", " + upper
+}
+
+// read returns the formatted value of the key.
+func (k *metaKeyDef) read(m *meta.Mutator) (string, bool) {
+	v, isNull, err := k.get(m)
+	mustNil(err)
+	return k.format(v, isNull), isNull
+}
+
+// write applies put or delete. A delete clears the key if it can be cleared,
+// otherwise it writes the default.
+func (k *metaKeyDef) write(m *meta.Mutator, opType string, v any) error {
+	if opType == "delete" {
+		if k.clear != nil {
+			return k.clear(m)
+		}
+		dv, err := k.parse(k.Default)
+		if err != nil {
+			return err
+		}
+		v = dv
+	}
+	return k.set(m, v)
+}
+
+// deletedValue is the formatted value of the key after delete.
+func (k *metaKeyDef) deletedValue() string {
+	if k.clear != nil {
+		return metaNullValue
+	}
+	return k.Default
+}
+
+// metaWrite is a validated get, put or delete of a meta key.
+type metaWrite struct {
+	key    *metaKeyDef
+	opType string
+	// value is the parsed value to put.
+	value any
+}
+
+func parseMetaWrite(name, opType, value string) (*metaWrite, error) {
+	w := &metaWrite{key: findMetaKey(name), opType: opType}
+	if w.key == nil {
+		return nil, fmt.Errorf("invalid key %s, run metacli list-keys for the supported keys", name)
+	}
+	if w.key.ReadOnly && opType != "get" {
+		return nil, fmt.Errorf("key %s is read-only, run metacli list-keys for how to change it", name)
+	}
+	switch opType {
+	case "get":
+	case "delete":
+		if w.key.clear == nil && w.key.Default == "" {
+			return nil, fmt.Errorf("key %s cannot be deleted", name)
+		}
+	case "put":
+		v, err := w.key.parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s for key %s: %v", value, name, err)
+		}
+		w.value = v
+	default:
+		return nil, fmt.Errorf("invalid operation type %s", opType)
+	}
+	return w, nil
+}
+
+// metaKeyValue is a row of list-keys.
+type metaKeyValue struct {
+	*metaKeyDef
+	Value string `json:"value"`
+}
+
+// listMetaKeys prints every supported key with its current value.
+func listMetaKeys(mCtx *metaCliCtx) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	store := openMetaStore(mCtx)
+	defer store.Close()
+
+	rows := make([]metaKeyValue, 0, len(metaKeys))
+	err := kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
+		m := meta.NewMutator(txn)
+		rows = rows[:0]
+		for _, k := range metaKeys {
+			v, _ := k.read(m)
+			rows = append(rows, metaKeyValue{metaKeyDef: k, Value: v})
+		}
+		return nil
+	})
+	mustNil(err)
+	if mCtx.jsonOutput {
+		printJSON(rows)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tRANGE\tDEFAULT\tVALUE\tDESCRIPTION\t")
+	for _, r := range rows {
+		def := r.Default
+		if def == "" {
+			def = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", r.Name, r.Type, r.rangeString(), def, r.Value, r.Description)
+	}
+	w.Flush()
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+)
+
+const (
+	defaultMetaBackupDir = "./metacli-backups"
+	defaultMetaAuditLog  = "./metacli-audit.log"
+	metaNullValue        = "<null>"
+)
+
+// metaChange is a write to a meta key. It is saved as the backup file, and
+// appended to the audit log as one JSON line.
+type metaChange struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	ClusterID uint64    `json:"cluster_id"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Backup    string    `json:"backup,omitempty"`
+}
+
+func newMetaChange(store kv.Storage, w *metaWrite, old string) *metaChange {
+	newValue := w.key.deletedValue()
+	if w.opType == "put" {
+		newValue = w.key.format(w.value, false)
+	}
+	return &metaChange{
+		Time:      time.Now(),
+		User:      currentUserName(),
+		ClusterID: store.GetClusterID(),
+		Op:        w.opType,
+		Key:       w.key.Name,
+		Old:       old,
+		New:       newValue,
+	}
+}
+
+func currentUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// saveMetaBackup writes the changes before they are applied, and returns the
+// path of the backup file. A single change is saved as an object, several
+// changes applied together as a list.
+func saveMetaBackup(dir string, changes []*metaChange) string {
+	err := os.MkdirAll(dir, 0755)
+	mustNil(err)
+	name, v := "apply", any(changes)
+	if len(changes) == 1 {
+		name, v = changes[0].Key, changes[0]
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, changes[0].Time.Format("20060102-150405.000")))
+	data, err := json.MarshalIndent(v, "", "  ")
+	mustNil(err)
+	err = os.WriteFile(path, data, 0644)
+	mustNil(err)
+	return path
+}
+
+func appendMetaAudit(path string, change *metaChange) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	mustNil(err)
+	defer f.Close()
+	data, err := json.Marshal(change)
+	mustNil(err)
+	_, err = f.Write(append(data, '\n'))
+	mustNil(err)
+}
+
+// restoreMetaKV reapplies the previous values saved in a backup file. A value
+// that was not set is restored with delete, which clears the key or resets it
+// to the default.
+func restoreMetaKV(mCtx *metaCliCtx, backupPath string) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	data, err := os.ReadFile(backupPath)
+	mustNil(err)
+	var changes []*metaChange
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &changes)
+	} else {
+		change := &metaChange{}
+		err = json.Unmarshal(data, change)
+		changes = append(changes, change)
+	}
+	mustNil(err)
+	if len(changes) == 0 {
+		mustNil(fmt.Errorf("no change found in %s", backupPath))
+	}
+
+	writes := make([]*metaWrite, 0, len(changes))
+	for _, change := range changes {
+		opType, value := "put", change.Old
+		if change.Old == metaNullValue {
+			opType, value = "delete", ""
+		}
+		w, err := parseMetaWrite(change.Key, opType, value)
+		mustNil(err)
+		writes = append(writes, w)
+		fmt.Printf("Restore key %s to %s from %s (cluster %d, %s)\n", change.Key, change.Old, backupPath,
+			change.ClusterID, change.Time.Format(time.RFC3339))
+	}
+	mCtx.restoreClusterID = changes[0].ClusterID
+	store := openMetaStore(mCtx)
+	defer store.Close()
+	applyMetaWrites(mCtx, store, writes)
+}
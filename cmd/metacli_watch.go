@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/kv"
+	"github.com/pingcap/tidb/pkg/meta"
+	"github.com/pingcap/tidb/pkg/meta/metadef"
+	"github.com/pingcap/tidb/pkg/meta/model"
+)
+
+// ingestMetaKeys are the ingest limits watched by metacli watch.
+var ingestMetaKeys = []string{
+	"max-batch-split-ranges",
+	"max-split-ranges-per-sec",
+	"max-ingest-per-sec",
+	"max-ingest-inflight",
+}
+
+// metaWatchSample is the ingest limits and the progress of the watched job at a time.
+type metaWatchSample struct {
+	time   time.Time
+	limits []string
+	job    *model.Job
+}
+
+// watchMeta polls the ingest limits, and the row count of a running DDL job
+// if --job-id is set, printing a line per poll until interrupted. A limit
+// changed since the previous poll is marked with "*".
+func watchMeta(mCtx *metaCliCtx) {
+	if !metaStoreConfigIsValid(mCtx) {
+		return
+	}
+	if mCtx.interval <= 0 {
+		fmt.Printf("invalid --interval %s, it must be positive\n", mCtx.interval)
+		return
+	}
+	store := openMetaStore(mCtx)
+	defer store.Close()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	keys := make([]*metaKeyDef, 0, len(ingestMetaKeys))
+	header := []string{fmt.Sprintf("%-8s", "TIME")}
+	for _, name := range ingestMetaKeys {
+		keys = append(keys, findMetaKey(name))
+		header = append(header, name)
+	}
+	if mCtx.jobID != 0 {
+		header = append(header, fmt.Sprintf("%12s", "ROWS"), fmt.Sprintf("%10s", "ROWS/S"))
+	}
+	fmt.Println(strings.Join(header, "  "))
+
+	var prev *metaWatchSample
+	ticker := time.NewTicker(mCtx.interval)
+	defer ticker.Stop()
+	for {
+		cur := readMetaWatchSample(store, keys, mCtx.jobID)
+		fmt.Println(formatMetaWatchSample(prev, cur, header, mCtx.jobID != 0))
+		if prev != nil && prev.job != nil && cur.job == nil {
+			fmt.Printf("job %d is no longer running\n", mCtx.jobID)
+		}
+		prev = cur
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return
+		}
+	}
+}
+
+func readMetaWatchSample(store kv.Storage, keys []*metaKeyDef, jobID int64) *metaWatchSample {
+	s := &metaWatchSample{time: time.Now()}
+	err := kv.RunInNewTxn(context.Background(), store, false, func(ctx context.Context, txn kv.Transaction) error {
+		m := meta.NewMutator(txn)
+		s.limits = s.limits[:0]
+		for _, k := range keys {
+			v, _ := k.read(m)
+			s.limits = append(s.limits, v)
+		}
+		if jobID == 0 {
+			return nil
+		}
+		snapshot := txn.GetSnapshot()
+		jobs, err := readDDLTableJobs(snapshot, meta.NewReader(snapshot), metadef.TiDBDDLJobTableID, 0, false)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if job.ID == jobID {
+				s.job = job
+			}
+		}
+		return nil
+	})
+	mustNil(err)
+	return s
+}
+
+// formatMetaWatchSample formats a sample in the columns of header, the rows
+// per second is computed from the row count of the previous sample.
+func formatMetaWatchSample(prev, cur *metaWatchSample, header []string, withJob bool) string {
+	cols := []string{cur.time.Format("15:04:05")}
+	for i, v := range cur.limits {
+		if prev != nil && prev.limits[i] != v {
+			v += "*"
+		}
+		cols = append(cols, fmt.Sprintf("%-*s", len(header[i+1]), v))
+	}
+	if withJob {
+		rows, speed := "-", "-"
+		if cur.job != nil {
+			rows = fmt.Sprint(cur.job.GetRowCount())
+			if prev != nil && prev.job != nil {
+				elapsed := cur.time.Sub(prev.time).Seconds()
+				speed = fmt.Sprintf("%.1f", float64(cur.job.GetRowCount()-prev.job.GetRowCount())/elapsed)
+			}
+		}
+		cols = append(cols, fmt.Sprintf("%12s", rows), fmt.Sprintf("%10s", speed))
+	}
+	return strings.Join(cols, "  ")
+}
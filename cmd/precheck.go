@@ -11,12 +11,10 @@ import (
 	"github.com/pingcap/tidb/pkg/ddl/schematracker"
 	"github.com/pingcap/tidb/pkg/parser"
 	"github.com/pingcap/tidb/pkg/parser/ast"
-	"github.com/pingcap/tidb/pkg/parser/format"
 	_ "github.com/pingcap/tidb/pkg/planner/core"
 	"github.com/pingcap/tidb/pkg/planner/core/resolve"
 	_ "github.com/pingcap/tidb/pkg/types/parser_driver"
 	"github.com/pingcap/tidb/pkg/util/chunk"
-	"github.com/pingcap/tidb/pkg/util/collate"
 	"github.com/pingcap/tidb/pkg/util/mock"
 	"github.com/pingcap/tidb/pkg/util/sqlexec"
 	"github.com/spf13/cobra"
@@ -28,30 +26,40 @@ type precheckCtx struct {
 	filePath         string
 	collationEnabled bool
 	verbose          bool
+	jsonOutput       bool
+	fastReorg        bool
 }
 
 func init() {
 	ctx := &precheckCtx{}
 	var precheckCmd = &cobra.Command{
-		Use: "precheck",
+		Use:   "precheck",
+		Short: "replay a migration file on a schema tracker and check every statement",
+		Long: "Replay a migration file on a schema tracker and print a verdict per statement: ok, lossy, blocked, error or skipped.\n" +
+			"It exits with 1 if any statement fails or is blocked, otherwise with 2 if a column change may lose data.",
 		Run: runPrecheckCmd(ctx),
 	}
 	rootCmd.AddCommand(precheckCmd)
 	precheckCmd.Flags().StringVarP(&ctx.filePath, "file", "f", "", "path to the SQL file to precheck")
-	precheckCmd.Flags().BoolVar(&ctx.collationEnabled, "new-collation", true, "whether the new collation feature is enabled")
-	precheckCmd.Flags().BoolVarP(&ctx.verbose, "verbose", "v", false, "print verbose output")
+	precheckCmd.Flags().BoolVar(&ctx.collationEnabled, "new-collation", true, "whether the new collation feature is enabled, i.e. whether collation changes of indexed columns rewrite the indexes")
+	precheckCmd.Flags().BoolVarP(&ctx.verbose, "verbose", "v", false, "print the full statements")
+	precheckCmd.Flags().BoolVar(&ctx.jsonOutput, "json", false, "print the verdicts in JSON")
+	precheckCmd.Flags().BoolVar(&ctx.fastReorg, "fast-reorg", true, "whether tidb_ddl_enable_fast_reorg is on, i.e. whether adding indexes can use ingest")
 }
 
 func runPrecheckCmd(ctx *precheckCtx) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
 		cmd.SetUsageFunc(func(c *cobra.Command) error {
-			fmt.Println("Usage: \n  precheck [flags]")
+			fmt.Println("Usage: \n  precheck [-f <file>] [flags]\n\nExits with 1 if any statement fails or is blocked, or 2 if a column change may lose data.")
 			return nil
 		})
 		precheckSQLFile(ctx)
 	}
 }
 
+// precheckSQLFile replays the statements of a migration file on a schema
+// tracker, and prints a verdict per statement. It exits with 1 if any
+// statement fails or is blocked, otherwise with 2 if any statement is lossy.
 func precheckSQLFile(ctx *precheckCtx) {
 	supressLogOutput()
 
@@ -60,52 +68,81 @@ func precheckSQLFile(ctx *precheckCtx) {
 		content = readFile(ctx.filePath)
 	} else {
 		var err error
-		fmt.Printf("Please input the SQL statements (end with Ctrl+D):\n")
+		fmt.Fprintf(os.Stderr, "Please input the SQL statements (end with Ctrl+D):\n")
 		content, err = io.ReadAll(os.Stdin)
 		printErrAndExit(err)
 	}
 
-	stmts := parseContent(string(content))
+	sql := string(content)
+	stmts := parseContent(sql)
 
 	checkStatements(stmts)
 
-	collate.SetNewCollationEnabledForTest(ctx.collationEnabled)
-	tracker := schematracker.NewSchemaTracker(0)
-	sessCtx := &mockCtx{mock.NewContext()}
-	isLossyChange := false
-	for _, stmt := range stmts {
-		isAlterTable := false
-		switch v := stmt.(type) {
-		case *ast.CreateDatabaseStmt:
-			err := tracker.CreateSchema(sessCtx, v)
-			printErrAndExit(err)
-		case *ast.CreateTableStmt:
-			err := tracker.CreateTable(sessCtx, v)
-			printErrAndExit(err)
-		case *ast.AlterTableStmt:
-			isAlterTable = true
-			err := tracker.AlterTable(context.Background(), sessCtx, v)
-			printErrAndExit(err)
-			// isLossyChange = tracker.Job.CtxVars[0].(bool)
-		default:
-			printErrAndExit(fmt.Errorf("Unsupported statement type: %T", v))
-		}
-		if ctx.verbose {
-			builder := strings.Builder{}
-			stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &builder))
-			fmt.Printf("Ok: %v\n", builder.String())
-			if isAlterTable {
-				fmt.Printf("Lossy change: %v\n", isLossyChange)
-			}
+	p := &precheckRunner{
+		tracker:      schematracker.NewSchemaTracker(0),
+		sessCtx:      &mockCtx{mock.NewContext()},
+		fastReorg:    ctx.fastReorg,
+		newCollation: ctx.collationEnabled,
+	}
+	verdicts := make([]*stmtVerdict, 0, len(stmts))
+	failed, lossy := false, false
+	// offset is the end of the previous statement, the lines are counted up to counted.
+	offset, counted, line := 0, 0, 1
+	for i, stmt := range stmts {
+		v := p.check(stmt)
+		v.No = i + 1
+		start := offset
+		text := strings.TrimSpace(stmt.Text())
+		if pos := strings.Index(sql[offset:], text); pos >= 0 {
+			start = offset + pos
+			offset = start + len(text)
 		}
+		pos := skipSQLComments(sql, start)
+		line += strings.Count(sql[counted:pos], "\n")
+		counted = pos
+		v.Line = line
+		failed = failed || v.Verdict == verdictError || v.Verdict == verdictBlocked
+		lossy = lossy || v.Verdict == verdictLossy
+		verdicts = append(verdicts, v)
+	}
+	if ctx.jsonOutput {
+		printJSON(verdicts)
+	} else {
+		printVerdicts(os.Stdout, verdicts, ctx.verbose)
+	}
+	if failed {
+		os.Exit(1)
 	}
-	if !ctx.verbose {
-		output := 0
-		if isLossyChange {
-			output = 1
+	if lossy {
+		os.Exit(2)
+	}
+}
+
+// skipSQLComments returns the position of the first token at or after i, the
+// text of a statement starts with the comments before it.
+func skipSQLComments(s string, i int) int {
+	for i < len(s) {
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 2
+		case strings.HasPrefix(rest, "--"), strings.HasPrefix(rest, "#"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 1
+		case strings.ContainsRune(" \t\r\n", rune(rest[0])):
+			i++
+		default:
+			return i
 		}
-		fmt.Printf("%d", output)
 	}
+	return i
 }
 
 func supressLogOutput() {
@@ -136,9 +173,6 @@ func checkStatements(stmts []ast.StmtNode) {
 	if len(stmts) == 0 {
 		printErrAndExit(fmt.Errorf("No statements found"))
 	}
-	if _, ok := stmts[len(stmts)-1].(*ast.AlterTableStmt); !ok {
-		printErrAndExit(fmt.Errorf("The last statement must be an ALTER TABLE statement"))
-	}
 }
 
 func printErrAndExit(err error) {
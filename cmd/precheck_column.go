@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/charset"
+	"github.com/pingcap/tidb/pkg/parser/mysql"
+	"github.com/pingcap/tidb/pkg/types"
+)
+
+// The kinds of a column change, from the cheapest to the most dangerous.
+const (
+	changeLossless = "lossless"
+	changeReorg    = "reorg"
+	changeLossy    = "lossy"
+)
+
+// columnChange is the classification of a MODIFY or CHANGE COLUMN.
+type columnChange struct {
+	Column  string   `json:"column"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Kind    string   `json:"kind"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// charsetSupersets lists the charsets whose characters can all be stored in the key charset.
+var charsetSupersets = map[string][]string{
+	charset.CharsetUTF8MB4: {charset.CharsetUTF8, charset.CharsetASCII, charset.CharsetLatin1, charset.CharsetGBK, charset.CharsetGB18030},
+	charset.CharsetUTF8:    {charset.CharsetASCII},
+	charset.CharsetLatin1:  {charset.CharsetASCII},
+	charset.CharsetGBK:     {charset.CharsetASCII},
+	charset.CharsetGB18030: {charset.CharsetASCII, charset.CharsetGBK},
+}
+
+// modifiedColumns returns the columns changed by the MODIFY and CHANGE COLUMN
+// specs, before and after the statement.
+func modifiedColumns(stmt *ast.AlterTableStmt, before, after *model.TableInfo, newCollation bool) []*columnChange {
+	var changes []*columnChange
+	for _, spec := range stmt.Specs {
+		if spec.Tp != ast.AlterTableModifyColumn && spec.Tp != ast.AlterTableChangeColumn {
+			continue
+		}
+		newName := spec.NewColumns[0].Name.Name
+		oldName := newName
+		if spec.OldColumnName != nil {
+			oldName = spec.OldColumnName.Name
+		}
+		oldCol := model.FindColumnInfo(before.Columns, oldName.L)
+		newCol := model.FindColumnInfo(after.Columns, newName.L)
+		if oldCol == nil || newCol == nil {
+			continue
+		}
+		changes = append(changes, classifyColumnChange(before, oldCol, newCol, newCollation))
+	}
+	return changes
+}
+
+// classifyColumnChange tells whether a column change of the table before the
+// statement only updates the metadata, needs to rewrite the data, or may lose
+// data. A collation change only rewrites the indexes with the new collation.
+func classifyColumnChange(before *model.TableInfo, oldCol, newCol *model.ColumnInfo, newCollation bool) *columnChange {
+	from, to := &oldCol.FieldType, &newCol.FieldType
+	c := &columnChange{Column: newCol.Name.O, From: from.String(), To: to.String(), Kind: changeLossless}
+	if oldCol.Name.L != newCol.Name.L {
+		c.Column = oldCol.Name.O + " -> " + newCol.Name.O
+	}
+	if _, err := types.CheckModifyTypeCompatible(from, to); err != nil ||
+		newCollation && collationNeedsReorg(from, to) && countIndexesWithColumn(before, oldCol.Name.L) > 0 {
+		c.Kind = changeReorg
+	}
+	c.Reasons = lossyReasons(from, to)
+	if len(c.Reasons) > 0 {
+		c.Kind = changeLossy
+	}
+	return c
+}
+
+// collationNeedsReorg tells whether the change of collation changes the encoded
+// index keys. It only matters if the column is indexed, the row values don't change.
+func collationNeedsReorg(from, to *types.FieldType) bool {
+	return types.IsString(from.GetType()) && types.IsString(to.GetType()) && from.GetCollate() != to.GetCollate()
+}
+
+func lossyReasons(from, to *types.FieldType) []string {
+	var reasons []string
+	ft, tt := from.GetType(), to.GetType()
+	unsignedChanged := mysql.HasUnsignedFlag(from.GetFlag()) != mysql.HasUnsignedFlag(to.GetFlag())
+	switch {
+	case ft == tt && (ft == mysql.TypeEnum || ft == mysql.TypeSet):
+		if len(to.GetElems()) < len(from.GetElems()) || !slices.Equal(from.GetElems(), to.GetElems()[:len(from.GetElems())]) {
+			reasons = append(reasons, "elements are removed or changed")
+		}
+	case types.IsString(ft) && types.IsString(tt):
+		if to.GetFlen() > 0 && to.GetFlen() < from.GetFlen() {
+			reasons = append(reasons, fmt.Sprintf("truncation from length %d to %d", from.GetFlen(), to.GetFlen()))
+		}
+		if charsetNarrowed(from.GetCharset(), to.GetCharset()) {
+			reasons = append(reasons, fmt.Sprintf("charset narrowing from %s to %s", from.GetCharset(), to.GetCharset()))
+		}
+	case mysql.IsIntegerType(ft) && mysql.IsIntegerType(tt):
+		if unsignedChanged {
+			reasons = append(reasons, "signedness change")
+		}
+		if integerDigits(to) < integerDigits(from) {
+			reasons = append(reasons, fmt.Sprintf("narrowing from %s to %s", from.String(), to.String()))
+		}
+	case (mysql.IsIntegerType(ft) || ft == mysql.TypeNewDecimal) && tt == mysql.TypeNewDecimal:
+		if unsignedChanged {
+			reasons = append(reasons, "signedness change")
+		}
+		if integerDigits(to) < integerDigits(from) || to.GetDecimal() < max(from.GetDecimal(), 0) {
+			reasons = append(reasons, fmt.Sprintf("precision reduction from %s to %s", from.CompactStr(), to.CompactStr()))
+		}
+	case types.IsTypeNumeric(ft) && isFloatType(tt):
+		if unsignedChanged {
+			reasons = append(reasons, "signedness change")
+		}
+		precision := integerDigits(from)
+		if ft == mysql.TypeNewDecimal {
+			precision = from.GetFlen()
+		}
+		if ft == mysql.TypeDouble && tt == mysql.TypeFloat || !isFloatType(ft) && precision > floatDigits(tt) ||
+			tt == ft && to.GetDecimal() >= 0 && (from.GetDecimal() < 0 || to.GetDecimal() < from.GetDecimal() || to.GetFlen() < from.GetFlen()) {
+			reasons = append(reasons, fmt.Sprintf("precision reduction from %s to %s", from.CompactStr(), to.CompactStr()))
+		}
+	case types.IsTypeTime(ft) && types.IsTypeTime(tt) || ft == mysql.TypeDuration && tt == mysql.TypeDuration:
+		if tt == mysql.TypeDate && ft != mysql.TypeDate {
+			reasons = append(reasons, "the time part is dropped")
+		}
+		if tt == mysql.TypeTimestamp && ft != mysql.TypeTimestamp {
+			reasons = append(reasons, "values out of the timestamp range")
+		}
+		if to.GetDecimal() < from.GetDecimal() && tt != mysql.TypeDate {
+			reasons = append(reasons, fmt.Sprintf("fractional seconds precision reduction from %d to %d", from.GetDecimal(), to.GetDecimal()))
+		}
+	case (types.IsTypeNumeric(ft) || types.IsTypeTime(ft) || ft == mysql.TypeDuration || ft == mysql.TypeYear) && types.IsString(tt):
+		if to.GetFlen() > 0 && to.GetFlen() < displayLength(from) {
+			reasons = append(reasons, fmt.Sprintf("truncation to length %d, which is less than %d of %s", to.GetFlen(), displayLength(from), from.CompactStr()))
+		}
+	case ft == tt:
+		if to.GetFlen() > 0 && to.GetFlen() < from.GetFlen() {
+			reasons = append(reasons, fmt.Sprintf("truncation from length %d to %d", from.GetFlen(), to.GetFlen()))
+		}
+	default:
+		reasons = append(reasons, fmt.Sprintf("conversion from %s to %s may fail or lose data", from.CompactStr(), to.CompactStr()))
+	}
+	return reasons
+}
+
+func charsetNarrowed(from, to string) bool {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if from == to || from == "" || to == "" || to == charset.CharsetBin {
+		return false
+	}
+	return !slices.Contains(charsetSupersets[to], from)
+}
+
+// integerDigits returns the number of the digits before the decimal point a numeric type can hold.
+func integerDigits(ft *types.FieldType) int {
+	switch ft.GetType() {
+	case mysql.TypeTiny:
+		return 3
+	case mysql.TypeShort:
+		return 5
+	case mysql.TypeInt24:
+		return 8
+	case mysql.TypeLong:
+		return 10
+	case mysql.TypeLonglong:
+		if mysql.HasUnsignedFlag(ft.GetFlag()) {
+			return 20
+		}
+		return 19
+	case mysql.TypeNewDecimal:
+		return ft.GetFlen() - max(ft.GetDecimal(), 0)
+	}
+	return ft.GetFlen()
+}
+
+func isFloatType(tp byte) bool {
+	return tp == mysql.TypeFloat || tp == mysql.TypeDouble
+}
+
+// floatDigits returns the significant decimal digits a float type keeps exactly.
+func floatDigits(tp byte) int {
+	if tp == mysql.TypeFloat {
+		return 6
+	}
+	return 15
+}
+
+// displayLength returns the max length of the value formatted as a string.
+func displayLength(ft *types.FieldType) int {
+	switch {
+	case mysql.IsIntegerType(ft.GetType()):
+		return integerDigits(ft) + 1
+	case ft.GetType() == mysql.TypeNewDecimal:
+		return ft.GetFlen() + 2
+	}
+	defaultFlen, _ := mysql.GetDefaultFieldLengthAndDecimal(ft.GetType())
+	return max(ft.GetFlen(), defaultFlen)
+}
+
+// worstChange returns the most dangerous kind of the changes, or "" if there is no change.
+func worstChange(changes []*columnChange) string {
+	kinds := []string{changeLossless, changeReorg, changeLossy}
+	worst := -1
+	for _, c := range changes {
+		worst = max(worst, slices.Index(kinds, c.Kind))
+	}
+	if worst < 0 {
+		return ""
+	}
+	return kinds[worst]
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pingcap/tidb/pkg/ddl"
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/util/mock"
+)
+
+// mockColumnTable builds a table from the column and index definitions.
+func mockColumnTable(t *testing.T, defs string) *model.TableInfo {
+	stmt, err := parser.New().ParseOneStmt("create table t ("+defs+")", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := ddl.MockTableInfo(mock.NewContext(), stmt.(*ast.CreateTableStmt), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+func TestClassifyColumnChange(t *testing.T) {
+	cases := []struct {
+		name    string
+		from    string
+		to      string
+		kind    string
+		reasons []string
+	}{
+		{"widen int", "c int", "c bigint", changeLossless, nil},
+		{"narrow int", "c bigint", "c int", changeLossy, []string{"narrowing from bigint(20) to int(11)"}},
+		{"to unsigned", "c int", "c int unsigned", changeLossy, []string{"signedness change"}},
+		{"to signed", "c bigint unsigned", "c bigint", changeLossy, []string{"signedness change", "narrowing from bigint(20) UNSIGNED to bigint(20)"}},
+		{"longer varchar", "c varchar(10)", "c varchar(20)", changeLossless, nil},
+		{"shorter varchar", "c varchar(20)", "c varchar(10)", changeLossy, []string{"truncation from length 20 to 10"}},
+		{"charset narrowing", "c varchar(10) charset utf8mb4", "c varchar(10) charset latin1", changeLossy, []string{"charset narrowing from utf8mb4 to latin1"}},
+		{"charset widening", "c varchar(10) charset utf8", "c varchar(10) charset utf8mb4", changeLossless, nil},
+		{"collation without index", "c varchar(10) collate utf8mb4_bin", "c varchar(10) collate utf8mb4_general_ci", changeLossless, nil},
+		{"collation with index", "c varchar(10) collate utf8mb4_bin, key i(c)", "c varchar(10) collate utf8mb4_general_ci, key i(c)", changeReorg, nil},
+		{"wider decimal", "c decimal(10,2)", "c decimal(12,2)", changeReorg, nil},
+		{"decimal scale", "c decimal(10,2)", "c decimal(10,1)", changeLossy, []string{"precision reduction from decimal(10,2) to decimal(10,1)"}},
+		{"decimal digits", "c decimal(10,2)", "c decimal(8,2)", changeLossy, []string{"precision reduction from decimal(10,2) to decimal(8,2)"}},
+		{"int to decimal", "c int", "c decimal(5,0)", changeLossy, []string{"precision reduction from int(11) to decimal(5,0)"}},
+		{"double to float", "c double", "c float", changeLossy, []string{"precision reduction from double to float"}},
+		{"bigint to double", "c bigint", "c double", changeLossy, []string{"precision reduction from bigint(20) to double"}},
+		{"int to double", "c int", "c double", changeReorg, nil},
+		{"float fraction", "c float(10,4)", "c float(10,2)", changeLossy, []string{"precision reduction from float(10,4) to float(10,2)"}},
+		{"enum append", "c enum('a','b')", "c enum('a','b','c')", changeLossless, nil},
+		{"enum remove", "c enum('a','b')", "c enum('a')", changeLossy, []string{"elements are removed or changed"}},
+		{"enum reorder", "c enum('a','b')", "c enum('b','a')", changeLossy, []string{"elements are removed or changed"}},
+		{"set remove", "c set('a','b','c')", "c set('a','c')", changeLossy, []string{"elements are removed or changed"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before, after := mockColumnTable(t, c.from), mockColumnTable(t, c.to)
+			got := classifyColumnChange(before, before.Columns[0], after.Columns[0], true)
+			if got.Kind != c.kind || !reflect.DeepEqual(got.Reasons, c.reasons) {
+				t.Fatalf("got %s %q, want %s %q", got.Kind, got.Reasons, c.kind, c.reasons)
+			}
+		})
+	}
+}
+
+func TestCollationChangeWithoutNewCollation(t *testing.T) {
+	before := mockColumnTable(t, "c varchar(10) collate utf8mb4_bin, key i(c)")
+	after := mockColumnTable(t, "c varchar(10) collate utf8mb4_general_ci, key i(c)")
+	if got := classifyColumnChange(before, before.Columns[0], after.Columns[0], false); got.Kind != changeLossless {
+		t.Fatalf("got %s, want %s", got.Kind, changeLossless)
+	}
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/meta/metadef"
+	"github.com/pingcap/tidb/pkg/meta/model"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// The ways TiDB executes a DDL, from the cheapest to the most expensive.
+const (
+	// costInstant only changes the metadata.
+	costInstant = "instant"
+	// costTiFlash builds the index on the TiFlash replicas, nothing is backfilled to TiKV.
+	costTiFlash = "tiflash"
+	// costBackfill reads the table to build new indexes, it can use ingest.
+	costBackfill = "backfill"
+	// costReorg rewrites the rows and the indexes of the changed columns in transactions.
+	costReorg = "reorg"
+)
+
+// stmtCost classifies a statement other than ALTER TABLE.
+func (p *precheckRunner) stmtCost(stmt ast.StmtNode, v *stmtVerdict) {
+	switch s := stmt.(type) {
+	case *ast.UseStmt:
+	case *ast.CreateIndexStmt:
+		if isTiFlashIndexKeyType(s.KeyType) {
+			v.Cost = costTiFlash
+			setTiFlashIngest(v)
+			break
+		}
+		v.Cost = costBackfill
+		p.setIngest(v, s.Table.Schema)
+	case *ast.TruncateTableStmt:
+		v.Cost = costInstant
+		v.Notes = append(v.Notes, "the old data is deleted by GC")
+	default:
+		v.Cost = costInstant
+	}
+}
+
+// alterTableCost classifies an ALTER TABLE by the most expensive spec, with
+// the table before the statement and the column changes already classified.
+func (p *precheckRunner) alterTableCost(stmt *ast.AlterTableStmt, before *model.TableInfo, v *stmtVerdict) {
+	costs := []string{costInstant, costTiFlash, costBackfill, costReorg}
+	worst := 0
+	raise := func(cost string) {
+		worst = max(worst, slices.Index(costs, cost))
+	}
+	for _, spec := range stmt.Specs {
+		switch spec.Tp {
+		case ast.AlterTableAddConstraint:
+			switch {
+			case isTiFlashIndexConstraint(spec.Constraint.Tp):
+				raise(costTiFlash)
+			case isIndexConstraint(spec.Constraint.Tp):
+				raise(costBackfill)
+			}
+		case ast.AlterTableReorganizePartition, ast.AlterTableCoalescePartitions, ast.AlterTablePartition,
+			ast.AlterTableRemovePartitioning:
+			raise(costReorg)
+			v.Notes = append(v.Notes, "the partitions are rewritten with their indexes")
+		}
+	}
+	reorgColumns := false
+	for _, c := range v.Columns {
+		if c.Kind == changeLossless {
+			continue
+		}
+		if !reorgColumns {
+			reorgColumns = true
+			v.Notes = append(v.Notes, "column type changes backfill in transactions, not with ingest")
+		}
+		raise(costReorg)
+		col := model.FindColumnInfo(before.Columns, strings.ToLower(firstColumnName(c.Column)))
+		if col == nil {
+			continue
+		}
+		if n := countIndexesWithColumn(before, col.Name.L); n > 0 {
+			v.Notes = append(v.Notes, fmt.Sprintf("%d index(es) on %s are rebuilt", n, col.Name.O))
+		}
+	}
+	v.Cost = costs[worst]
+	switch v.Cost {
+	case costTiFlash:
+		setTiFlashIngest(v)
+	case costBackfill:
+		p.setIngest(v, stmt.Table.Schema)
+	case costReorg:
+		v.Ingest = "no"
+	}
+}
+
+// setIngest tells whether the index backfill uses ingest, i.e. fast reorg.
+func (p *precheckRunner) setIngest(v *stmtVerdict, schema ast.CIStr) {
+	switch {
+	case !p.fastReorg:
+		v.Ingest = "no"
+		v.Notes = append(v.Notes, "tidb_ddl_enable_fast_reorg is off")
+	case metadef.IsSystemRelatedDB(schema.L):
+		v.Ingest = "no"
+		v.Notes = append(v.Notes, "fast reorg is disabled for system databases")
+	default:
+		v.Ingest = "yes"
+	}
+}
+
+// setTiFlashIngest marks an index built by TiFlash, it never uses ingest.
+func setTiFlashIngest(v *stmtVerdict) {
+	v.Ingest = "no"
+	v.Notes = append(v.Notes, "the index is built by TiFlash, the table needs a TiFlash replica")
+}
+
+// isTiFlashIndexConstraint tells whether the index is built by TiFlash instead of backfilled on TiKV.
+func isTiFlashIndexConstraint(tp ast.ConstraintType) bool {
+	return tp == ast.ConstraintFulltext || tp == ast.ConstraintVector || tp == ast.ConstraintColumnar
+}
+
+func isTiFlashIndexKeyType(tp ast.IndexKeyType) bool {
+	return tp == ast.IndexKeyTypeFulltext || tp == ast.IndexKeyTypeVector || tp == ast.IndexKeyTypeColumnar
+}
+
+func isIndexConstraint(tp ast.ConstraintType) bool {
+	switch tp {
+	case ast.ConstraintPrimaryKey, ast.ConstraintKey, ast.ConstraintIndex, ast.ConstraintUniq,
+		ast.ConstraintUniqKey, ast.ConstraintUniqIndex, ast.ConstraintFulltext, ast.ConstraintVector,
+		ast.ConstraintColumnar:
+		return true
+	}
+	return false
+}
+
+// firstColumnName returns the old name of a "old -> new" renamed column.
+func firstColumnName(name string) string {
+	old, _, _ := strings.Cut(name, " -> ")
+	return old
+}
+
+func countIndexesWithColumn(tbl *model.TableInfo, colName string) int {
+	n := 0
+	for _, idx := range tbl.Indices {
+		if _, col := model.FindIndexColumnByName(idx.Columns, colName); col != nil {
+			n++
+		}
+	}
+	if tbl.PKIsHandle && tbl.GetPkName().L == colName {
+		n++
+	}
+	return n
+}
+
+// alterTableBlocked returns why TiDB rejects the statement before running
+// it, or "" if it is not blocked.
+func alterTableBlocked(stmt *ast.AlterTableStmt) string {
+	for _, spec := range stmt.Specs {
+		if spec.Tp != ast.AlterTableAddColumns {
+			continue
+		}
+		for _, col := range spec.NewColumns {
+			for _, opt := range col.Options {
+				if opt.Tp == ast.ColumnOptionGenerated && opt.Stored {
+					return fmt.Sprintf("adding the stored generated column %s is not supported", col.Name.Name.O)
+				}
+			}
+		}
+	}
+	if reason := multiSchemaChangeBlocked(stmt); reason != "" {
+		return "unsupported multi-schema change: " + reason
+	}
+	return ""
+}
+
+// multiSchemaChangeBlocked returns why TiDB rejects the multi-schema change,
+// or "" if it is allowed. It follows the checks TiDB does when merging the
+// specs into one job: only some kinds of specs can be combined, and a column
+// or an index can only be changed by one spec.
+func multiSchemaChangeBlocked(stmt *ast.AlterTableStmt) string {
+	specs := stmt.Specs
+	isMulti := len(specs) > 1 || len(specs) == 1 && specs[0].Tp == ast.AlterTableAddColumns && len(specs[0].NewColumns) > 1
+	if !isMulti {
+		return ""
+	}
+	var (
+		addCols, dropCols, modifyCols, positionCols, relativeCols []string
+		addIdxes, dropIdxes, alterIdxes                           []string
+	)
+	for _, spec := range specs {
+		switch spec.Tp {
+		case ast.AlterTableAddColumns:
+			for _, col := range spec.NewColumns {
+				addCols = append(addCols, col.Name.Name.L)
+				relativeCols = append(relativeCols, generatedColumnDeps(col)...)
+			}
+		case ast.AlterTableDropColumn:
+			dropCols = append(dropCols, spec.OldColumnName.Name.L)
+		case ast.AlterTableDropIndex:
+			dropIdxes = append(dropIdxes, strings.ToLower(spec.Name))
+		case ast.AlterTableDropPrimaryKey:
+			dropIdxes = append(dropIdxes, "primary")
+		case ast.AlterTableAddConstraint:
+			c := spec.Constraint
+			if c.Tp == ast.ConstraintForeignKey {
+				continue
+			}
+			if !isIndexConstraint(c.Tp) {
+				return "this constraint cannot be combined with other changes"
+			}
+			name := strings.ToLower(c.Name)
+			if c.Tp == ast.ConstraintPrimaryKey {
+				name = "primary"
+			}
+			if name != "" {
+				addIdxes = append(addIdxes, name)
+			}
+			for _, key := range c.Keys {
+				if key.Column != nil {
+					relativeCols = append(relativeCols, key.Column.Name.L)
+				}
+			}
+		case ast.AlterTableRenameIndex:
+			addIdxes = append(addIdxes, spec.ToKey.L)
+			dropIdxes = append(dropIdxes, spec.FromKey.L)
+		case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn, ast.AlterTableRenameColumn:
+			newName := spec.NewColumnName
+			if len(spec.NewColumns) > 0 {
+				newName = spec.NewColumns[0].Name
+			}
+			oldName := newName
+			if spec.OldColumnName != nil {
+				oldName = spec.OldColumnName
+			}
+			if oldName.Name.L != newName.Name.L {
+				addCols = append(addCols, newName.Name.L)
+				dropCols = append(dropCols, oldName.Name.L)
+			} else {
+				modifyCols = append(modifyCols, newName.Name.L)
+			}
+		case ast.AlterTableAlterColumn:
+			modifyCols = append(modifyCols, spec.NewColumns[0].Name.Name.L)
+		case ast.AlterTableIndexInvisible:
+			alterIdxes = append(alterIdxes, spec.IndexName.L)
+		case ast.AlterTableOption:
+			for _, opt := range spec.Options {
+				switch opt.Tp {
+				case ast.TableOptionAutoIncrement, ast.TableOptionComment, ast.TableOptionCharset, ast.TableOptionCollate:
+				default:
+					return "this table option cannot be combined with other changes"
+				}
+			}
+		case ast.AlterTableDropForeignKey:
+		default:
+			return "this kind of change cannot be combined with other changes"
+		}
+		if spec.Position != nil && spec.Position.Tp == ast.ColumnPositionAfter {
+			positionCols = append(positionCols, spec.Position.RelativeColumn.Name.L)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, names := range []struct {
+		names []string
+		add   bool
+	}{{addCols, true}, {dropCols, true}, {positionCols, false}, {modifyCols, true}, {relativeCols, false}} {
+		for _, name := range names.names {
+			if seen[name] {
+				return fmt.Sprintf("column %s is operated more than once", name)
+			}
+			if names.add {
+				seen[name] = true
+			}
+		}
+	}
+	seen = make(map[string]bool)
+	for _, names := range [][]string{addIdxes, dropIdxes, alterIdxes} {
+		for _, name := range names {
+			if seen[name] {
+				return fmt.Sprintf("index %s is operated more than once", name)
+			}
+			seen[name] = true
+		}
+	}
+	return ""
+}
+
+// generatedColumnDeps returns the columns a generated column depends on.
+func generatedColumnDeps(col *ast.ColumnDef) []string {
+	var deps []string
+	for _, opt := range col.Options {
+		if opt.Tp != ast.ColumnOptionGenerated {
+			continue
+		}
+		c := &columnNameCollector{}
+		opt.Expr.Accept(c)
+		deps = append(deps, c.names...)
+	}
+	return deps
+}
+
+type columnNameCollector struct {
+	names []string
+}
+
+func (c *columnNameCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if col, ok := n.(*ast.ColumnName); ok {
+		c.names = append(c.names, col.Name.L)
+	}
+	return n, false
+}
+
+func (c *columnNameCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
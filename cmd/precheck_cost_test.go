@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/pkg/ddl/schematracker"
+	"github.com/pingcap/tidb/pkg/util/mock"
+)
+
+// precheckLast replays the statements and returns the verdict of the last one.
+func precheckLast(t *testing.T, sql string, fastReorg bool) *stmtVerdict {
+	p := &precheckRunner{
+		tracker:      schematracker.NewSchemaTracker(0),
+		sessCtx:      &mockCtx{mock.NewContext()},
+		fastReorg:    fastReorg,
+		newCollation: true,
+	}
+	stmts := parseContent(sql)
+	var v *stmtVerdict
+	for _, stmt := range stmts {
+		v = p.check(stmt)
+	}
+	return v
+}
+
+const precheckCostSetup = "create database d; use d; create table t (a int, b varchar(20), c int, v vector(3), key ia(a), key IB(b));\n"
+
+func TestMultiSchemaChangeBlocked(t *testing.T) {
+	cases := []struct {
+		name    string
+		sql     string
+		blocked string
+	}{
+		{"add columns", "alter table t add column x int, add column y int", ""},
+		{"add column and index", "alter table t add column x int, add index ix(a)", ""},
+		{"same column twice", "alter table t modify column a bigint, drop column a", "column a is operated more than once"},
+		{"index on dropped column", "alter table t drop column c, add index ic(c)", "column c is operated more than once"},
+		{"position after added column", "alter table t add column x int, add column y int after x", "column x is operated more than once"},
+		{"rename and drop index", "alter table t rename index ia to ja, drop index IA", "index ia is operated more than once"},
+		{"drop index in other case", "alter table t drop index ib, alter index IB invisible", "index ib is operated more than once"},
+		{"add the same index", "alter table t add index IX(a), add index ix(c)", "index ix is operated more than once"},
+		{"add and drop primary key", "alter table t add primary key (a), drop primary key", "index primary is operated more than once"},
+		{"rename table", "alter table t add column x int, rename to t2", "this kind of change cannot be combined with other changes"},
+		{"table option", "alter table t add column x int, shard_row_id_bits = 2", "this table option cannot be combined with other changes"},
+		{"comment option", "alter table t add column x int, comment = 'x'", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := precheckLast(t, precheckCostSetup+c.sql, true)
+			if c.blocked == "" {
+				if v.Verdict == verdictBlocked {
+					t.Fatalf("blocked: %s", v.Message)
+				}
+				return
+			}
+			if v.Verdict != verdictBlocked || !strings.HasSuffix(v.Message, c.blocked) {
+				t.Fatalf("got %s %q, want blocked %q", v.Verdict, v.Message, c.blocked)
+			}
+		})
+	}
+}
+
+func TestStatementCost(t *testing.T) {
+	cases := []struct {
+		name      string
+		sql       string
+		fastReorg bool
+		cost      string
+		ingest    string
+	}{
+		{"add column", "alter table t add column x int", true, costInstant, ""},
+		{"drop index", "alter table t drop index ia", true, costInstant, ""},
+		{"add index", "alter table t add index ix(c)", true, costBackfill, "yes"},
+		{"add index without fast reorg", "alter table t add index ix(c)", false, costBackfill, "no"},
+		{"create index", "create index ix on t(c)", true, costBackfill, "yes"},
+		{"create unique index without fast reorg", "create unique index ux on t(c)", false, costBackfill, "no"},
+		{"add index to system table", "create database mysql; create table mysql.x (a int); alter table mysql.x add index ia(a)", true, costBackfill, "no"},
+		{"add vector index", "alter table t add vector index iv((vec_cosine_distance(v)))", true, costTiFlash, "no"},
+		{"create columnar index", "create columnar index ic on t(c)", true, costTiFlash, "no"},
+		{"vector and normal index", "alter table t add vector index iv((vec_cosine_distance(v))), add index ix(c)", true, costBackfill, "yes"},
+		{"widen column", "alter table t modify column a bigint", true, costInstant, ""},
+		{"change column type", "alter table t modify column a varchar(20)", true, costReorg, "no"},
+		{"shrink indexed column with index", "alter table t modify column b varchar(10), add index ix(c)", true, costReorg, "no"},
+		{"partition table", "alter table t partition by hash(a) partitions 4", true, costReorg, "no"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := precheckLast(t, precheckCostSetup+c.sql, c.fastReorg)
+			if v.Verdict == verdictError || v.Verdict == verdictBlocked {
+				t.Fatalf("%s: %s", v.Verdict, v.Message)
+			}
+			if v.Cost != c.cost || v.Ingest != c.ingest {
+				t.Fatalf("got cost %s ingest %q, want %s %q (%v)", v.Cost, v.Ingest, c.cost, c.ingest, v.Notes)
+			}
+		})
+	}
+}
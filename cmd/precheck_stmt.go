@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pingcap/tidb/pkg/ddl/schematracker"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+)
+
+const (
+	verdictOK      = "ok"
+	verdictError   = "error"
+	verdictSkipped = "skipped"
+	// verdictBlocked is a statement TiDB rejects although it is valid on the schema.
+	verdictBlocked = "blocked"
+	// verdictLossy is a statement that succeeds, but a column change of it may lose data.
+	verdictLossy = "lossy"
+)
+
+// stmtVerdict is the precheck result of a statement.
+type stmtVerdict struct {
+	No      int    `json:"no"`
+	Line    int    `json:"line"`
+	Kind    string `json:"kind"`
+	SQL     string `json:"sql"`
+	Verdict string `json:"verdict"`
+	Message string `json:"message,omitempty"`
+	// Change is the most dangerous kind of the column changes.
+	Change  string          `json:"change,omitempty"`
+	Columns []*columnChange `json:"columns,omitempty"`
+	// Cost is how TiDB runs the statement, Ingest tells whether its backfill uses ingest.
+	Cost   string   `json:"cost,omitempty"`
+	Ingest string   `json:"ingest,omitempty"`
+	Notes  []string `json:"notes,omitempty"`
+}
+
+// precheckRunner replays the statements on the tracker, the current database
+// is set by USE.
+type precheckRunner struct {
+	tracker   schematracker.SchemaTracker
+	sessCtx   *mockCtx
+	currentDB string
+	// fastReorg is whether tidb_ddl_enable_fast_reorg is on.
+	fastReorg bool
+	// newCollation is whether the new collation feature is enabled.
+	newCollation bool
+}
+
+func (p *precheckRunner) check(stmt ast.StmtNode) *stmtVerdict {
+	v := &stmtVerdict{Kind: stmtKind(stmt), SQL: restoreStmt(stmt), Verdict: verdictOK}
+	if _, ok := stmt.(ast.DDLNode); !ok {
+		if _, ok := stmt.(*ast.UseStmt); !ok {
+			v.Verdict = verdictSkipped
+			v.Message = "not a DDL statement, ignored"
+			return v
+		}
+	}
+	if !p.qualifyTableNames(stmt) {
+		v.Verdict, v.Message = verdictError, "no database selected"
+		return v
+	}
+	if alter, ok := stmt.(*ast.AlterTableStmt); ok {
+		// Check before applying, so the blocked statement doesn't change the schema.
+		if reason := alterTableBlocked(alter); reason != "" {
+			v.Verdict, v.Message = verdictBlocked, reason
+			return v
+		}
+	}
+	supported, err := p.apply(stmt, v)
+	switch {
+	case !supported:
+		v.Verdict = verdictSkipped
+		v.Message = "unsupported DDL statement, ignored"
+	case err != nil:
+		v.Verdict, v.Message = verdictError, err.Error()
+	case v.Change == changeLossy:
+		v.Verdict = verdictLossy
+		var reasons []string
+		for _, c := range v.Columns {
+			if len(c.Reasons) > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", c.Column, strings.Join(c.Reasons, ", ")))
+			}
+		}
+		v.Message = strings.Join(reasons, "; ")
+	}
+	return v
+}
+
+// apply replays a statement on the tracker, it returns false if the statement is not supported.
+func (p *precheckRunner) apply(stmt ast.StmtNode, verdict *stmtVerdict) (bool, error) {
+	var err error
+	switch v := stmt.(type) {
+	case *ast.UseStmt:
+		if p.tracker.SchemaByName(ast.NewCIStr(v.DBName)) == nil {
+			return true, fmt.Errorf("Unknown database '%s'", v.DBName)
+		}
+		p.currentDB = v.DBName
+		p.sessCtx.GetSessionVars().CurrentDB = v.DBName
+	case *ast.CreateDatabaseStmt:
+		err = p.tracker.CreateSchema(p.sessCtx, v)
+	case *ast.AlterDatabaseStmt:
+		err = p.tracker.AlterSchema(p.sessCtx, v)
+	case *ast.DropDatabaseStmt:
+		err = p.tracker.DropSchema(p.sessCtx, v)
+	case *ast.CreateTableStmt:
+		err = p.tracker.CreateTable(p.sessCtx, v)
+	case *ast.CreateViewStmt:
+		err = p.tracker.CreateView(p.sessCtx, v)
+	case *ast.DropTableStmt:
+		if v.IsView {
+			err = p.tracker.DropView(p.sessCtx, v)
+		} else {
+			err = p.tracker.DropTable(p.sessCtx, v)
+		}
+	case *ast.RenameTableStmt:
+		err = p.tracker.RenameTable(p.sessCtx, v)
+	case *ast.TruncateTableStmt:
+		// Truncate only changes the table ID.
+		_, err = p.tracker.TableByName(context.Background(), v.Table.Schema, v.Table.Name)
+	case *ast.CreateIndexStmt:
+		err = p.tracker.CreateIndex(p.sessCtx, v)
+	case *ast.DropIndexStmt:
+		err = p.tracker.DropIndex(p.sessCtx, v)
+	case *ast.AlterTableStmt:
+		err = p.alterTable(v, verdict)
+	default:
+		return false, nil
+	}
+	if _, ok := stmt.(*ast.AlterTableStmt); !ok && err == nil {
+		p.stmtCost(stmt, verdict)
+	}
+	return true, err
+}
+
+// alterTable applies the statement and classifies the column changes and the
+// cost by the table info before and after it.
+func (p *precheckRunner) alterTable(stmt *ast.AlterTableStmt, verdict *stmtVerdict) error {
+	ctx := context.Background()
+	before, err := p.tracker.TableByName(ctx, stmt.Table.Schema, stmt.Table.Name)
+	if err != nil {
+		return err
+	}
+	// The tracker changes the table info in place.
+	before = before.Clone()
+	err = p.tracker.AlterTable(ctx, p.sessCtx, stmt)
+	if err != nil {
+		return err
+	}
+	newTable := stmt.Table
+	for _, spec := range stmt.Specs {
+		if spec.Tp == ast.AlterTableRenameTable {
+			newTable = spec.NewTable
+		}
+	}
+	after, err := p.tracker.TableByName(ctx, newTable.Schema, newTable.Name)
+	if err != nil {
+		return err
+	}
+	verdict.Columns = modifiedColumns(stmt, before, after, p.newCollation)
+	verdict.Change = worstChange(verdict.Columns)
+	p.alterTableCost(stmt, before, verdict)
+	return nil
+}
+
+// qualifyTableNames fills the current database into the table names without
+// one, it returns false if there is such a name but no current database.
+func (p *precheckRunner) qualifyTableNames(stmt ast.StmtNode) bool {
+	q := &tableNameQualifier{db: ast.NewCIStr(p.currentDB)}
+	stmt.Accept(q)
+	return !q.missing
+}
+
+type tableNameQualifier struct {
+	db      ast.CIStr
+	missing bool
+}
+
+func (q *tableNameQualifier) Enter(n ast.Node) (ast.Node, bool) {
+	if t, ok := n.(*ast.TableName); ok && t.Schema.L == "" {
+		if q.db.L == "" {
+			q.missing = true
+		}
+		t.Schema = q.db
+	}
+	return n, false
+}
+
+func (q *tableNameQualifier) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// stmtKind returns the statement type, e.g. "AlterTable" for *ast.AlterTableStmt.
+func stmtKind(stmt ast.StmtNode) string {
+	kind := fmt.Sprintf("%T", stmt)
+	kind = strings.TrimPrefix(kind, "*ast.")
+	return strings.TrimSuffix(kind, "Stmt")
+}
+
+func restoreStmt(stmt ast.StmtNode) string {
+	builder := strings.Builder{}
+	err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &builder))
+	if err != nil {
+		return strings.Join(strings.Fields(stmt.Text()), " ")
+	}
+	return builder.String()
+}
+
+// printVerdicts prints a line per statement, the statements are shortened unless verbose.
+func printVerdicts(w io.Writer, verdicts []*stmtVerdict, verbose bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NO\tLINE\tKIND\tVERDICT\tCOST\tINGEST\tCHANGE\tSQL\tMESSAGE\t")
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	for _, v := range verdicts {
+		sql := v.SQL
+		if runes := []rune(sql); !verbose && len(runes) > 60 {
+			sql = string(runes[:57]) + "..."
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", v.No, v.Line, v.Kind, v.Verdict,
+			orDash(v.Cost), orDash(v.Ingest), orDash(v.Change), sql, v.Message)
+	}
+	tw.Flush()
+	if verbose {
+		for _, v := range verdicts {
+			for _, c := range v.Columns {
+				fmt.Fprintf(w, "#%d %s: %s -> %s, %s %s\n", v.No, c.Column, c.From, c.To, c.Kind, strings.Join(c.Reasons, ", "))
+			}
+			for _, note := range v.Notes {
+				fmt.Fprintf(w, "#%d note: %s\n", v.No, note)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tangenta/dbtool/util"
+	"golang.org/x/sync/errgroup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type profileCtx struct {
+	kubeCfgPath string
+	namespace   string
+	addrs       []string
+	components  []string
+	profiles    []string
+	cpuDuration time.Duration
+	outDir      string
+
+	clientset *kubernetes.Clientset
+}
+
+// profileTarget is an instance whose status port is collected.
+type profileTarget struct {
+	component string
+	name      string
+	fetch     func(ctx context.Context, path string) ([]byte, error)
+}
+
+// componentStatusPorts are the status ports of the components in a TidbCluster.
+var componentStatusPorts = map[string]string{
+	"tidb": "10080",
+	"pd":   "2379",
+	"tikv": "20180",
+}
+
+// componentProfilePaths maps profile names to the status port paths of each component.
+func componentProfilePaths(component string, cpuSeconds int) map[string]string {
+	pprof := "/debug/pprof"
+	if component == "pd" {
+		pprof = "/pd/api/v1/debug/pprof"
+	}
+	paths := map[string]string{
+		"heap.pb.gz": pprof + "/heap",
+		"cpu.pb.gz":  fmt.Sprintf("%s/profile?seconds=%d", pprof, cpuSeconds),
+	}
+	switch component {
+	case "tidb":
+		paths["goroutine.txt"] = pprof + "/goroutine?debug=2"
+		paths["mutex.pb.gz"] = pprof + "/mutex"
+		paths["block.pb.gz"] = pprof + "/block"
+		paths["status.json"] = "/status"
+		paths["config.json"] = "/config"
+	case "pd":
+		paths["goroutine.txt"] = pprof + "/goroutine?debug=2"
+		paths["mutex.pb.gz"] = pprof + "/mutex"
+		paths["block.pb.gz"] = pprof + "/block"
+		paths["status.json"] = "/pd/api/v1/status"
+		paths["config.json"] = "/pd/api/v1/config"
+	case "tikv":
+		paths["status.json"] = "/status"
+		paths["config.json"] = "/config"
+	}
+	return paths
+}
+
+func init() {
+	ctx := &profileCtx{}
+	var profileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "collect profiles from all TiDB instances into a tarball",
+		Run:   runProfileCmd(ctx),
+	}
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.Flags().StringVar(&ctx.kubeCfgPath, "kubecfg", "./kubeconfig.yml", "Set the path of kube config file.")
+	profileCmd.Flags().StringVar(&ctx.namespace, "namespace", "", "Set the namespace of TiDB cluster.")
+	profileCmd.Flags().StringSliceVar(&ctx.addrs, "addr", nil, "Collect from the status addresses instead of Kubernetes, e.g. tidb=127.0.0.1:10080,pd=127.0.0.1:2379.")
+	profileCmd.Flags().StringSliceVar(&ctx.components, "components", []string{"tidb"}, "Set the components to collect, supports tidb, pd, tikv.")
+	profileCmd.Flags().StringSliceVar(&ctx.profiles, "profiles", nil, "Only collect the given profiles, e.g. goroutine,heap,cpu,mutex,block,status,config.")
+	profileCmd.Flags().DurationVar(&ctx.cpuDuration, "cpu-duration", 30*time.Second, "Set the duration of CPU profiles.")
+	profileCmd.Flags().StringVar(&ctx.outDir, "out", ".", "Set the directory to write the tarball.")
+}
+
+func runProfileCmd(ctx *profileCtx) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		var targets []profileTarget
+		if len(ctx.addrs) > 0 {
+			targets = ctx.addrTargets()
+		} else {
+			_, ctx.clientset = util.BuildClientSetFromCfg(ctx.kubeCfgPath)
+			ctx.namespace = detectNamespace(ctx.clientset, ctx.kubeCfgPath, ctx.namespace)
+			targets = ctx.podTargets()
+		}
+		if len(targets) == 0 {
+			fmt.Println("No instance found.")
+			return
+		}
+		ctx.collect(targets)
+	}
+}
+
+// addrTargets parses --addr, an address without a component prefix is TiDB.
+func (p *profileCtx) addrTargets() []profileTarget {
+	targets := make([]profileTarget, 0, len(p.addrs))
+	for _, addr := range p.addrs {
+		component := "tidb"
+		if c, a, ok := strings.Cut(addr, "="); ok {
+			component, addr = c, a
+		}
+		if _, ok := componentStatusPorts[component]; !ok {
+			panic(fmt.Sprintf("unsupported component %q", component))
+		}
+		base := addr
+		if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+			base = "http://" + base
+		}
+		targets = append(targets, profileTarget{
+			component: component,
+			name:      strings.NewReplacer(":", "_", "/", "_").Replace(addr),
+			fetch: func(ctx context.Context, path string) ([]byte, error) {
+				return httpGet(ctx, base+path)
+			},
+		})
+	}
+	return targets
+}
+
+// podTargets discovers the pods of the components, and reaches them through the API server proxy.
+func (p *profileCtx) podTargets() []profileTarget {
+	var targets []profileTarget
+	for _, component := range p.components {
+		port, ok := componentStatusPorts[component]
+		if !ok {
+			panic(fmt.Sprintf("unsupported component %q", component))
+		}
+		pods, err := p.clientset.CoreV1().Pods(p.namespace).List(context.Background(), metav1.ListOptions{
+			LabelSelector: "app.kubernetes.io/component=" + component,
+		})
+		mustNil(err)
+		for _, pod := range pods.Items {
+			name := pod.Name
+			targets = append(targets, profileTarget{
+				component: component,
+				name:      name,
+				fetch: func(ctx context.Context, path string) ([]byte, error) {
+					u, params := splitQuery(path)
+					return p.clientset.CoreV1().Pods(p.namespace).ProxyGet("http", name, port, u, params).DoRaw(ctx)
+				},
+			})
+		}
+		log.Printf("Found %d %s instance(s).\n", len(pods.Items), component)
+	}
+	return targets
+}
+
+func splitQuery(path string) (string, map[string]string) {
+	u, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path, nil
+	}
+	params := make(map[string]string)
+	for _, kv := range strings.Split(query, "&") {
+		k, v, _ := strings.Cut(kv, "=")
+		params[k] = v
+	}
+	return u, params
+}
+
+func httpGet(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (p *profileCtx) wanted(file string) bool {
+	if len(p.profiles) == 0 {
+		return true
+	}
+	name, _, _ := strings.Cut(file, ".")
+	for _, want := range p.profiles {
+		if want == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collect fetches all profiles concurrently and writes them into one tarball.
+func (p *profileCtx) collect(targets []profileTarget) {
+	start := time.Now()
+	var mu sync.Mutex
+	files := make(map[string][]byte)
+	var failures []string
+
+	eg := errgroup.Group{}
+	eg.SetLimit(16)
+	for _, t := range targets {
+		for file, path := range componentProfilePaths(t.component, int(p.cpuDuration.Seconds())) {
+			if !p.wanted(file) {
+				continue
+			}
+			name := fmt.Sprintf("%s/%s/%s", t.component, t.name, file)
+			eg.Go(func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), p.cpuDuration+time.Minute)
+				defer cancel()
+				data, err := t.fetch(ctx, path)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failures = append(failures, fmt.Sprintf("%s: %s", name, err.Error()))
+					log.Printf("Collect %s ✘\n", name)
+					return nil
+				}
+				files[name] = data
+				log.Printf("Collect %s ✔\n", name)
+				return nil
+			})
+		}
+	}
+	_ = eg.Wait()
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		files["errors.txt"] = []byte(strings.Join(failures, "\n") + "\n")
+	}
+
+	err := os.MkdirAll(p.outDir, 0755)
+	mustNil(err)
+	base := fmt.Sprintf("profile-%s", start.Format("20060102-150405"))
+	path := filepath.Join(p.outDir, base+".tar.gz")
+	writeTarball(path, base, files, start)
+	fmt.Printf("Collected %d file(s) from %d instance(s), %d failure(s): %s\n", len(files), len(targets), len(failures), path)
+}
+
+func writeTarball(path, root string, files map[string][]byte, modTime time.Time) {
+	f, err := os.Create(path)
+	mustNil(err)
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, name := range sortedKeys(files) {
+		data := files[name]
+		err = tw.WriteHeader(&tar.Header{
+			Name:    root + "/" + name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+		})
+		mustNil(err)
+		_, err = tw.Write(data)
+		mustNil(err)
+	}
+	mustNil(tw.Close())
+	mustNil(gw.Close())
+}